@@ -5,10 +5,16 @@ go 1.13
 require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/Shopify/sarama v1.23.1
+	github.com/golang/protobuf v1.5.2
 	github.com/gorilla/mux v1.7.3
+	github.com/linkedin/goavro/v2 v2.10.1
 	github.com/mateuszdyminski/am-pipeline/models v0.0.0-20190919094627-bec8d1e2eafe
 	github.com/olivere/elastic/v7 v7.0.6
 	github.com/prometheus/client_golang v1.1.0
 	github.com/rs/zerolog v1.15.0
 	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 )
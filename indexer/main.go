@@ -2,40 +2,138 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
-	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/server"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/pipeline"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/signals"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var configPath string
+var (
+	configPath string
+	dryRun     bool
+	source     string
+	sink       string
+	verbose    bool
+	limit      int64
+	replay     bool
+	confirm    bool
+	selfTest   bool
+	printCfg   bool
+	profile    string
+	printMap   bool
+	tail       bool
+	reprocess  bool
+)
 
 func init() {
 	flag.Usage = func() {
 		flag.PrintDefaults()
 	}
 
-	flag.StringVar(&configPath, "config", "config/conf.toml", "config path")
+	flag.StringVar(&configPath, "config", "config/conf.toml", "config file path or http(s) URL")
+	flag.StringVar(&profile, "profile", "", "name of the [profiles.<name>] section of the config file applied over [default]")
+	flag.StringVar(&source, "source", config.SourceKafka, "source of users: kafka or file")
+	flag.StringVar(&sink, "sink", config.SinkElastic, "destination of users: elastic, stdout or file")
+	flag.BoolVar(&dryRun, "dry-run", false, "log users at debug level instead of indexing them in Elasticsearch")
+	flag.BoolVar(&verbose, "verbose", false, "log every indexed user at debug level")
+	flag.BoolVar(&replay, "replay", false, "reset offsets of the consumer group to the oldest and reindex all users")
+	flag.BoolVar(&confirm, "confirm-replay", false, "confirm -replay, required to avoid accidental replays")
+	flag.BoolVar(&selfTest, "selftest", false, "index synthetic user, read it back and delete it, then exit")
+	flag.BoolVar(&printCfg, "print-config", false, "print resolved config with passwords redacted and exit")
+	flag.BoolVar(&printMap, "print-mapping", false, "print mapping of created indices and exit")
+	flag.BoolVar(&tail, "tail", false, "consume topics from the newest offset without consumer group, offsets are never committed")
+	flag.BoolVar(&reprocess, "reprocess-dlq", false, "pass messages of the dead letter topic through the pipeline again, then exit")
+	flag.Int64Var(&limit, "limit", 0, "stop after given number of users is consumed, 0 means no limit")
 }
 
 func main() {
 	// load config
 	flag.Parse()
 
-	cfg, err := config.LoadConfig(configPath)
+	cfg, err := config.LoadConfigProfile(configPath, profile)
 	if err != nil {
 		log.Fatal("can't load config file", err)
 	}
 
-	ctx := signals.SetupSignalContext()
-	indexer, err := indexer.NewIndexer(cfg)
+	cfg.Source = source
+	cfg.Sink = sink
+	cfg.DryRun = dryRun
+	cfg.Verbose = verbose
+	cfg.Limit = limit
+	cfg.Replay = replay
+	cfg.SelfTest = selfTest
+	cfg.Tail = tail
+	cfg.ReprocessDLQ = reprocess
+	if printCfg {
+		if err := cfg.WriteTOML(os.Stdout); err != nil {
+			log.Fatal("can't print config", err)
+		}
+		return
+	}
+	if printMap {
+		mapping, err := indexer.Mapping(cfg)
+		if err != nil {
+			log.Fatal("can't print mapping: ", err)
+		}
+		fmt.Println(string(mapping))
+		return
+	}
+	if cfg.Replay && !confirm {
+		log.Fatal("-replay resets offsets of the consumer group, run it with -confirm-replay to proceed")
+	}
+
+	if err := setupLogging(cfg); err != nil {
+		log.Fatal("can't setup logging", err)
+	}
+
+	if cfg.DryRun || cfg.Verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if cfg.DryRun {
+		log.Warn("Dry-run mode is active! Users are logged only and not indexed in Elasticsearch")
+	}
+
+	p, err := pipeline.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := p.Run(signals.SetupSignalContext()); err != nil {
+		if cfg.SelfTest {
+			log.Fatal("selftest failed: ", err)
+		}
+		log.Fatal("indexer stopped with error: ", err)
+	}
+
+	if cfg.SelfTest {
+		log.Info("selftest passed")
+		return
+	}
+	log.Info("indexer stopped")
+}
+
+// setupLogging configures format and level of the logs.
+func setupLogging(cfg *config.Config) error {
+	switch cfg.LogFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	default:
+		return fmt.Errorf("invalid LogFormat %q, must be one of: text, json", cfg.LogFormat)
+	}
+
+	level, err := log.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		log.Fatal("can't create indexer", err)
+		return err
 	}
-	go indexer.Index()
+	log.SetLevel(level)
 
-	server.ListenAndServe(cfg, ctx)
+	return nil
 }
@@ -1,34 +1,640 @@
 package config
 
 import (
-	"io/ioutil"
+	"strings"
+	"time"
 
-	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
 )
 
 // Config holds configuration of feeder.
 type Config struct {
-	Brokers        []string
-	Topic          string
-	HTTPPort       int
-	ReadFromOldest bool
+	Brokers []string
+	Topics  []string
+	// Topic is kept for backward compatibility, it's used only when Topics is empty
+	Topic    string
+	HTTPPort int
+	// ConsumerGroup name of the Kafka consumer group, separate deployments consuming the same topics need different names
+	ConsumerGroup string
+	// CheckTopicExists fails startup when any of the Topics doesn't exist, enabled when not set
+	CheckTopicExists *bool
+	// Partitions consumed from every topic, e.g. by instances owning distinct partitions. Partitions are not balanced
+	// by the consumer group when set, it's used only to store offsets. All partitions are consumed when empty.
+	Partitions []int32
+	// ClientID identifies connections of the indexer in broker logs and metrics, "ts-indexer" by default
+	ClientID string
+	// ClientIDHostname appends hostname to the ClientID so instances of the indexer can be told apart
+	ClientIDHostname bool
+	// InitialOffset used when consumer group has no committed offset: "oldest" (default) or "newest"
+	InitialOffset string
+	// ReadFromOldest is kept for backward compatibility, true means "oldest" and false "newest" InitialOffset. It's
+	// used only when InitialOffset is empty.
+	ReadFromOldest *bool
+	// StartTimestamp (RFC3339) consumer starts at the first message produced at or after it, overrides committed offsets
+	StartTimestamp string
+	// CommitInterval how often marked offsets are committed, lower values mean fewer users reprocessed after a crash
+	CommitInterval Duration
+	// CommitStrategy "after-enqueue" (default) marks offset once user is passed to the sink, users buffered on crash
+	// are lost. "after-index" marks offset once user is written by the sink, users may be indexed again after crash.
+	CommitStrategy string
+	// MaxConsecutiveErrors consumer group is rejoined after this number of errors without any consumed message
+	MaxConsecutiveErrors int
+	// ShutdownTimeout how long remaining users are flushed on shutdown before the indexer exits anyway
+	ShutdownTimeout Duration
+	// RebalanceFlushTimeout how long pending users are flushed when partitions are revoked by rebalance, offsets are
+	// committed before partitions are given up
+	RebalanceFlushTimeout Duration
+	// LagInterval how often consumer lag is reported, disabled when not set
+	LagInterval Duration
 
-	Elastics        []string
+	// SASL/PLAIN credentials, authentication is disabled when both are empty
+	KafkaUsername string
+	KafkaPassword string
+
+	// MessageFormat format of Kafka messages: "json" (default), "avro" or "protobuf"
+	MessageFormat string
+	// MessageTimeout limits decoding and transforming of a single message, slower messages are handled as messages
+	// which can't be decoded. Waiting for the sink is not limited. No limit when not set.
+	MessageTimeout Duration
+	// StrictDecode rejects JSON messages with fields unknown to the indexer, they are handled as messages which can't
+	// be decoded. Unknown fields are ignored by default.
+	StrictDecode bool
+	// SchemaRegistryURL url of the Confluent schema registry with Avro schemas of messages
+	SchemaRegistryURL string
+	// DeadLetterTopic receives messages which can't be decoded, oversized users, users rejected by Elasticsearch with
+	// permanent errors and users failing whole bulk request, disabled when empty
+	DeadLetterTopic string
+	// RetryTopic receives messages which can't be decoded before they are dead-lettered, disabled when empty. It's
+	// consumed together with Topics, every message is processed RetryDelay after it was published.
+	RetryTopic string
+	// RetryDelay delay of consuming messages from the RetryTopic
+	RetryDelay Duration
+	// MaxRetryAttempts number of times message goes through the RetryTopic before it's dead-lettered
+	MaxRetryAttempts int
+
+	Elastics []string
+
+	// HTTP basic auth credentials, basic auth is disabled when both are empty
 	ElasticUser     string
 	ElasticPassword string
+
+	// TLS config, server certificate is not verified when ElasticCACert is empty
+	ElasticCACert     string
+	ElasticClientCert string
+	ElasticClientKey  string
+
+	// ElasticSniff enables discovery of the cluster nodes, disabled by default as it doesn't work behind load balancers
+	ElasticSniff bool
+	// ElasticGzip compresses bodies of requests sent to Elasticsearch
+	ElasticGzip bool
+	// ReplicaElastics urls of the cluster receiving copy of all writes, disabled when empty. Credentials and TLS
+	// settings are shared with the primary cluster
+	ReplicaElastics []string
+	// ElasticHealthcheck enables periodic health checks of the nodes, enabled when not set
+	ElasticHealthcheck *bool
+
+	// ElasticConnectRetries how many times connection to Elasticsearch is retried at startup
+	ElasticConnectRetries int
+	// ElasticConnectBackoff delay before the first retry, it doubles with every attempt
+	ElasticConnectBackoff Duration
+	// ElasticTimeout timeout of a single request to Elasticsearch
+	ElasticTimeout Duration
+	// ElasticMaxIdleConns limits idle connections to all nodes kept for reuse
+	ElasticMaxIdleConns int
+	// ElasticMaxIdleConnsPerHost limits idle connections to a single node, it should be above the number of concurrent
+	// bulk requests, otherwise connections are closed and opened again after every bulk
+	ElasticMaxIdleConnsPerHost int
+	// ElasticIdleConnTimeout closes connections which were idle for longer
+	ElasticIdleConnTimeout Duration
+
+	// ChannelBuffer size of the buffer between Kafka consumer and indexing workers
+	ChannelBuffer int
+
+	// LogFormat "text" (default) or "json"
+	LogFormat string
+	// LogLevel e.g. "debug", "info" (default), "warn"
+	LogLevel string
+	// TracingEndpoint host:port of the OTLP/HTTP collector receiving traces, tracing is disabled when empty
+	TracingEndpoint string
+	// TracingInsecure sends traces over plain HTTP
+	TracingInsecure bool
+	// TracingSampleRatio fraction of traces which are sampled, all by default
+	TracingSampleRatio float64
+	// LogSampleFirst number of repeated errors of the same kind logged every minute before sampling starts
+	LogSampleFirst int
+	// LogSampleEvery only every n-th repeated error is logged once LogSampleFirst errors were logged
+	LogSampleEvery int
+
+	BulkSize int
+	// MaxBulkBytes bulk request is flushed before its documents exceed this size, no limit when not set
+	MaxBulkBytes int
+	// MaxDocBytes users with bigger Elasticsearch documents are skipped and sent to the dead letter topic, no limit when
+	// not set. Other sinks are not limited.
+	// It should be lower than http.max_content_length of Elasticsearch, otherwise such user fails every bulk request.
+	MaxDocBytes int
+	// RefreshPolicy of bulk requests: "" (default) and "false" leave refreshes to the refresh_interval of the index,
+	// "wait_for" blocks bulk until its users are searchable, "true" refreshes affected shards after every bulk. Both
+	// lower the throughput, "true" significantly as it creates many small segments; don't use it for backfills.
+	RefreshPolicy string
+	FlushInterval Duration
+	MaxRetries    int
+	BaseBackoff   Duration
+	// Workers number of goroutines indexing users in parallel, each with its own bulk request
+	Workers int
+	// DedupeBatch sends only the last update of every user in the bulk request, it can't be used with UpdateMode
+	DedupeBatch bool
+	// UnchangedCacheSize number of documents which hashes are remembered, snapshots of users equal to the last indexed
+	// document are skipped. Disabled when not set.
+	UnchangedCacheSize int
+	// MaxDocsPerSecond limits indexing throughput, e.g. during backfills, unlimited when not set
+	MaxDocsPerSecond int
+	// MaxInFlightBulks limits number of concurrent bulk requests of all workers, unlimited when not set
+	MaxInFlightBulks int
+	// ShardBy routes all users with the same key to the same worker so their updates are indexed in order: "partition"
+	// or "id". By default users go to the first free worker which gives the best throughput but may reorder updates,
+	// unless RoutingField is set, then users with the same routing go to the same worker. Sharded workers are as slow
+	// as the busiest shard.
+	ShardBy string
+
+	// IndexPattern may contain %Y, %m and %d placeholders, e.g. "users-%Y.%m", and %f placeholder replaced with the
+	// value of IndexField, e.g. "users-%f".
+	IndexPattern string
+	// WriteAlias users are written through the alias instead of the IndexPattern, so its index can be switched without
+	// restarting the indexer, see POST /alias?index=<name>
+	WriteAlias string
+	// WriteAliasIndex index the WriteAlias is pointed to at startup, alias must already exist when it's empty
+	WriteAliasIndex string
+	// IndexShards number of primary shards of created indices, Elasticsearch default when not set
+	IndexShards int
+	// IndexReplicas number of replicas of created indices, 0 disables replicas, Elasticsearch default when not set
+	IndexReplicas *int
+	// IndexRefreshInterval refresh interval of created indices, e.g. "30s" or "-1" to disable refreshes, Elasticsearch
+	// default when not set
+	IndexRefreshInterval string
+	// MappingTemplate path to the JSON index template installed at startup, e.g. with "index_patterns": ["users-*"]
+	MappingTemplate string
+	// AutoUpdateMapping adds fields missing in the mapping of existing indices
+	AutoUpdateMapping bool
+	// MappingRequiredFields JSON names of document fields which must be in the users mapping, e.g. ["id", "dob"],
+	// startup fails otherwise. Other fields missing in the mapping or in the document are only reported.
+	MappingRequiredFields []string
+	// IndexField name of the user field selecting index of the user, e.g. "Country" for index per country
+	IndexField string
+	// IndexDateField selects the date used to format IndexPattern: "now" (default) or "dob".
+	IndexDateField string
+	// Pipeline name of the ingest pipeline documents are passed through, no pipeline is used when empty
+	Pipeline string
+	// UpdateMode merges documents with the already indexed ones instead of replacing them
+	UpdateMode bool
+	// DocType type of indexed documents, "_doc" by default. Custom types, e.g. "user", are accepted by Elasticsearch 6
+	// and older only
+	DocType string
+	// IDField name of the user field used as document id, "Pnum" by default
+	IDField string
+	// RoutingField name of the user field used to route documents to shards, e.g. "Country", routing by id when empty.
+	// Unless ShardBy is set, bulk requests of workers are grouped by routing so they touch fewer shards.
+	RoutingField string
+
+	// DobPolicy handles users without date of birth: "nullify" (default), "drop" or "default"
+	DobPolicy string
+	// DobDefault date of birth (YYYY-MM-DD) set when DobPolicy is "default"
+	DobDefault string
+	// SkipEmailPattern regular expression of emails of users which are not indexed, e.g. test users
+	SkipEmailPattern string
+	// SkipPnumRange inclusive range [from, to] of Pnums of users which are not indexed
+	SkipPnumRange []int64
+	// Transformers names of transformations applied to every user in order, e.g. ["trim", "lowercase_email"]
+	Transformers []string
+	// IndexedAtField name of the document field set to the UTC time user is indexed at, e.g. "indexed_at", it's mapped
+	// as date. Disabled when empty.
+	IndexedAtField string
+	// RenameFields renames fields of indexed documents and of the users mapping, e.g. { id = "person_id" }. Keys are
+	// JSON names of the fields.
+	RenameFields map[string]string
+	// EnrichURL of the service which user is POSTed to before indexing, fields of its JSON response are merged into the
+	// document. Users are indexed unenriched when the service fails. Disabled when empty.
+	EnrichURL string
+	// EnrichTimeout timeout of the enrichment request
+	EnrichTimeout Duration
+	// EnrichConcurrency maximum number of concurrent enrichment requests. Users are enriched by the index workers one at
+	// a time, so it has effect only when it's lower than Workers.
+	EnrichConcurrency int
+	// RedactFields names of the user text fields pseudonymized before indexing, e.g. ["Email", "Nickname"]. Fields used
+	// as IDField, RoutingField or IndexField can't be redacted.
+	RedactFields []string
+	// RedactMode "hash" (default) replaces redacted fields with their SHA-256, "mask" replaces them with "***"
+	RedactMode string
+	// RetentionDays users with RetentionField older than given number of days are periodically deleted, disabled when 0
+	RetentionDays int
+	// RetentionField name of the date field of indexed documents compared with RetentionDays
+	RetentionField string
+	// RetentionInterval how often expired users are deleted
+	RetentionInterval Duration
+
+	// FilePath newline-delimited JSON file with users, used when Source is "file"
+	FilePath string
+	// Source is set by the -source flag: "kafka" (default) or "file"
+	Source string `toml:"-"`
+
+	// Sink is set by the -sink flag: "elastic" (default), "stdout" or "file"
+	Sink string `toml:"-"`
+	// SinkPath file users are written to when Sink is "file"
+	SinkPath string
+	// ExtraSinks users are copied to concurrently with the Sink, e.g. [[ExtraSinks]] with Type = "kafka" and
+	// Topic = "users-analytics". They are not used in dry-run mode.
+	ExtraSinks []SinkConfig
+
+	// DryRun is set by the -dry-run flag, users are logged instead of being indexed
+	DryRun bool `toml:"-"`
+	// Verbose is set by the -verbose flag, every indexed user is logged
+	Verbose bool `toml:"-"`
+	// Limit is set by the -limit flag, indexer stops after consuming given number of users, 0 means no limit
+	Limit int64 `toml:"-"`
+	// Replay is set by the -replay flag, offsets of the consumer group are reset to the oldest before joining it
+	Replay bool `toml:"-"`
+	// SelfTest is set by the -selftest flag, synthetic user is indexed, read back and deleted without consuming Kafka
+	SelfTest bool `toml:"-"`
+	// Tail is set by the -tail flag, Topics are consumed from the newest offset without consumer group, offsets are
+	// never committed and failed messages are not dead-lettered
+	Tail bool `toml:"-"`
+	// ReprocessDLQ is set by the -reprocess-dlq flag, messages of the DeadLetterTopic published before the start are
+	// passed through the pipeline again and the indexer exits. Documents dead-lettered by the index step are skipped.
+	// Failed messages are not dead-lettered again unless ReprocessRequeue is set.
+	ReprocessDLQ bool `toml:"-"`
+	// ReprocessRequeue sends messages failing again during -reprocess-dlq back to the DeadLetterTopic, so they are
+	// retried by the next run. Otherwise they are only counted and dropped, their offsets are committed and the next run
+	// doesn't see them.
+	ReprocessRequeue bool
 }
 
-// LoadConfig loads config from env vars.
+const (
+	// DefaultRetentionInterval is used when RetentionInterval is not set in the config file.
+	DefaultRetentionInterval = time.Hour
+	// DefaultClientID is used when ClientID is not set in the config file.
+	DefaultClientID = "ts-indexer"
+	// DefaultConsumerGroup is used when ConsumerGroup is not set in the config file. It's the name used before the
+	// group became configurable, so existing deployments keep their committed offsets.
+	DefaultConsumerGroup = "consumer-group"
+	// DefaultCommitInterval is used when CommitInterval is not set in the config file. It's below the sarama default
+	// (1s), so fewer users are indexed again after a crash or rebalance.
+	DefaultCommitInterval = 100 * time.Millisecond
+	// DefaultRetryDelay is used when RetryDelay is not set in the config file.
+	DefaultRetryDelay = time.Minute
+	// DefaultMaxRetryAttempts is used when MaxRetryAttempts is not set in the config file.
+	DefaultMaxRetryAttempts = 3
+	// DefaultMaxConsecutiveErrors is used when MaxConsecutiveErrors is not set in the config file.
+	DefaultMaxConsecutiveErrors = 10
+	// DefaultShutdownTimeout is used when ShutdownTimeout is not set in the config file. It's below the default
+	// termination grace period of Kubernetes pods.
+	DefaultShutdownTimeout = 25 * time.Second
+	// DefaultRebalanceFlushTimeout is used when RebalanceFlushTimeout is not set in the config file. It's below the
+	// default rebalance timeout of the consumer group.
+	DefaultRebalanceFlushTimeout = 10 * time.Second
+	// DefaultChannelBuffer is used when ChannelBuffer is not set in the config file.
+	DefaultChannelBuffer = 1024
+	// DefaultLogFormat is used when LogFormat is not set in the config file.
+	DefaultLogFormat = "text"
+	// DefaultLogLevel is used when LogLevel is not set in the config file.
+	DefaultLogLevel = "info"
+	// DefaultTracingSampleRatio is used when TracingSampleRatio is not set in the config file.
+	DefaultTracingSampleRatio = 1
+	// DefaultLogSampleFirst is used when LogSampleFirst is not set in the config file.
+	DefaultLogSampleFirst = 10
+	// DefaultLogSampleEvery is used when LogSampleEvery is not set in the config file.
+	DefaultLogSampleEvery = 100
+	// DefaultElasticConnectRetries is used when ElasticConnectRetries is not set in the config file.
+	DefaultElasticConnectRetries = 5
+	// DefaultElasticConnectBackoff is used when ElasticConnectBackoff is not set in the config file.
+	DefaultElasticConnectBackoff = 2 * time.Second
+	// DefaultElasticTimeout is used when ElasticTimeout is not set in the config file.
+	DefaultElasticTimeout = 30 * time.Second
+	// DefaultElasticMaxIdleConns is used when ElasticMaxIdleConns is not set in the config file.
+	DefaultElasticMaxIdleConns = 100
+	// DefaultElasticMaxIdleConnsPerHost is used when ElasticMaxIdleConnsPerHost is not set in the config file. Standard
+	// library keeps only 2 idle connections per host.
+	DefaultElasticMaxIdleConnsPerHost = 32
+	// DefaultElasticIdleConnTimeout is used when ElasticIdleConnTimeout is not set in the config file.
+	DefaultElasticIdleConnTimeout = 90 * time.Second
+	// DefaultBulkSize is used when BulkSize is not set in the config file.
+	DefaultBulkSize = 100
+	// DefaultFlushInterval is used when FlushInterval is not set in the config file.
+	DefaultFlushInterval = 5 * time.Second
+	// DefaultMaxRetries is used when MaxRetries is not set in the config file.
+	DefaultMaxRetries = 5
+	// DefaultBaseBackoff is used when BaseBackoff is not set in the config file.
+	DefaultBaseBackoff = 500 * time.Millisecond
+	// DefaultWorkers is used when Workers is not set in the config file.
+	DefaultWorkers = 1
+	// DefaultInitialOffset is used when InitialOffset is not set in the config file.
+	DefaultInitialOffset = "oldest"
+	// DefaultEnrichTimeout is used when EnrichTimeout is not set in the config file.
+	DefaultEnrichTimeout = 2 * time.Second
+	// DefaultEnrichConcurrency is used when EnrichConcurrency is not set in the config file.
+	DefaultEnrichConcurrency = 8
+	// DefaultDocType is used when DocType is not set in the config file.
+	DefaultDocType = "_doc"
+	// DefaultIDField is used when IDField is not set in the config file.
+	DefaultIDField = "Pnum"
+	// DefaultDobPolicy is used when DobPolicy is not set in the config file.
+	DefaultDobPolicy = "nullify"
+	// DefaultIndexPattern is used when IndexPattern is not set in the config file.
+	DefaultIndexPattern = "users"
+)
+
+// Sources of users.
+const (
+	SourceKafka = "kafka"
+	SourceFile  = "file"
+	// SourceCustom is the source provided by the program embedding the indexer, see pipeline.WithSource.
+	SourceCustom = "custom"
+)
+
+// Formats of Kafka messages.
+const (
+	// MessageFormatJSON is the JSON encoded user.
+	MessageFormatJSON = "json"
+	// MessageFormatAvro is the Avro encoded user with schema in the schema registry.
+	MessageFormatAvro = "avro"
+	// MessageFormatProtobuf is the protobuf encoded user, see userpb/user.proto.
+	MessageFormatProtobuf = "protobuf"
+)
+
+// Modes of redacting fields.
+const (
+	// RedactModeHash replaces value with its SHA-256 hash.
+	RedactModeHash = "hash"
+	// RedactModeMask replaces value with a fixed mask.
+	RedactModeMask = "mask"
+)
+
+// Strategies of committing offsets.
+const (
+	// CommitAfterEnqueue commits offset once user is passed to the sink, at-most-once.
+	CommitAfterEnqueue = "after-enqueue"
+	// CommitAfterIndex commits offset once user is written by the sink, at-least-once.
+	CommitAfterIndex = "after-index"
+)
+
+// Keys of sharding users between workers.
+const (
+	// ShardByPartition keeps order of Kafka partitions.
+	ShardByPartition = "partition"
+	// ShardByID keeps order of updates of the same user.
+	ShardByID = "id"
+)
+
+// Sinks of users.
+const (
+	SinkElastic = "elastic"
+	SinkStdout  = "stdout"
+	SinkFile    = "file"
+	SinkKafka   = "kafka"
+	// SinkCustom is the sink provided by the program embedding the indexer, see pipeline.WithSink.
+	SinkCustom = "custom"
+)
+
+// SinkConfig is a sink users are copied to.
+type SinkConfig struct {
+	// Type of the sink: "kafka", "stdout" or "file"
+	Type string
+	// Topic documents are produced to by the kafka sink, keyed by the document id
+	Topic string
+	// Path of the file sink
+	Path string
+	// FailurePolicy "best-effort" (default) logs failed writes, "fatal" stops the indexer
+	FailurePolicy string
+}
+
+// Policies of handling failed writes to extra sinks.
+const (
+	SinkFailureBestEffort = "best-effort"
+	SinkFailureFatal      = "fatal"
+)
+
+// UsesElastic returns true when users are indexed in Elasticsearch.
+func (c *Config) UsesElastic() bool {
+	return !c.DryRun && (c.Sink == "" || c.Sink == SinkElastic)
+}
+
+// Duration allows to decode time.Duration from TOML strings like "5s".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// LoadConfig loads config from the file or URL and applies environment variables overrides.
 func LoadConfig(configPath string) (*Config, error) {
-	bytes, err := ioutil.ReadFile(configPath)
+	return LoadConfigProfile(configPath, "")
+}
+
+// LoadConfigProfile loads config like LoadConfig with the named profile of the config file applied before
+// environment variables overrides.
+func LoadConfigProfile(configPath, profile string) (*Config, error) {
+	bytes, err := readConfig(configPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var conf Config
-	if err := toml.Unmarshal(bytes, &conf); err != nil {
+	if err := decodeConfig(bytes, &conf, profile); err != nil {
+		return nil, err
+	}
+
+	if err := applyEnvOverrides(&conf); err != nil {
 		return nil, err
 	}
 
+	conf.SetDefaults()
+
 	return &conf, nil
 }
+
+// SetDefaults fills in the optional fields which were not provided in the config file. Programs embedding the indexer
+// call it on configs built in code before Validate.
+func (c *Config) SetDefaults() {
+	if len(c.Topics) == 0 && c.Topic != "" {
+		c.Topics = []string{c.Topic}
+	}
+
+	for i, broker := range c.Brokers {
+		if addr, chroot := splitChroot(broker); chroot != "" {
+			log.Warnf("Zookeeper chroot %q of Brokers[%d] %q ignored, consumer group connects to the brokers directly", chroot, i, broker)
+			c.Brokers[i] = addr
+		}
+	}
+
+	if c.InitialOffset == "" && c.ReadFromOldest != nil {
+		c.InitialOffset = "newest"
+		if *c.ReadFromOldest {
+			c.InitialOffset = "oldest"
+		}
+	}
+
+	if c.InitialOffset == "" {
+		c.InitialOffset = DefaultInitialOffset
+	}
+
+	if c.CheckTopicExists == nil {
+		check := true
+		c.CheckTopicExists = &check
+	}
+
+	if c.ElasticHealthcheck == nil {
+		healthcheck := true
+		c.ElasticHealthcheck = &healthcheck
+	}
+
+	if c.ElasticConnectRetries <= 0 {
+		c.ElasticConnectRetries = DefaultElasticConnectRetries
+	}
+
+	if c.ElasticConnectBackoff.Duration <= 0 {
+		c.ElasticConnectBackoff.Duration = DefaultElasticConnectBackoff
+	}
+
+	if c.ElasticTimeout.Duration <= 0 {
+		c.ElasticTimeout.Duration = DefaultElasticTimeout
+	}
+
+	if c.ElasticMaxIdleConns <= 0 {
+		c.ElasticMaxIdleConns = DefaultElasticMaxIdleConns
+	}
+
+	if c.ElasticMaxIdleConnsPerHost <= 0 {
+		c.ElasticMaxIdleConnsPerHost = DefaultElasticMaxIdleConnsPerHost
+	}
+
+	if c.ElasticIdleConnTimeout.Duration <= 0 {
+		c.ElasticIdleConnTimeout.Duration = DefaultElasticIdleConnTimeout
+	}
+
+	if c.MessageFormat == "" {
+		c.MessageFormat = MessageFormatJSON
+	}
+
+	if c.RetentionInterval.Duration <= 0 {
+		c.RetentionInterval.Duration = DefaultRetentionInterval
+	}
+
+	if c.ClientID == "" {
+		c.ClientID = DefaultClientID
+	}
+
+	if c.ConsumerGroup == "" {
+		c.ConsumerGroup = DefaultConsumerGroup
+	}
+
+	if c.CommitInterval.Duration <= 0 {
+		c.CommitInterval.Duration = DefaultCommitInterval
+	}
+
+	if c.RetryDelay.Duration <= 0 {
+		c.RetryDelay.Duration = DefaultRetryDelay
+	}
+
+	if c.MaxRetryAttempts <= 0 {
+		c.MaxRetryAttempts = DefaultMaxRetryAttempts
+	}
+
+	if c.MaxConsecutiveErrors <= 0 {
+		c.MaxConsecutiveErrors = DefaultMaxConsecutiveErrors
+	}
+
+	if c.ShutdownTimeout.Duration <= 0 {
+		c.ShutdownTimeout.Duration = DefaultShutdownTimeout
+	}
+
+	if c.RebalanceFlushTimeout.Duration <= 0 {
+		c.RebalanceFlushTimeout.Duration = DefaultRebalanceFlushTimeout
+	}
+
+	if c.ChannelBuffer <= 0 {
+		c.ChannelBuffer = DefaultChannelBuffer
+	}
+
+	if c.LogFormat == "" {
+		c.LogFormat = DefaultLogFormat
+	}
+
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+
+	if c.TracingSampleRatio <= 0 {
+		c.TracingSampleRatio = DefaultTracingSampleRatio
+	}
+
+	if c.LogSampleFirst <= 0 {
+		c.LogSampleFirst = DefaultLogSampleFirst
+	}
+
+	if c.LogSampleEvery <= 0 {
+		c.LogSampleEvery = DefaultLogSampleEvery
+	}
+
+	if c.BulkSize <= 0 {
+		c.BulkSize = DefaultBulkSize
+	}
+
+	if c.FlushInterval.Duration <= 0 {
+		c.FlushInterval.Duration = DefaultFlushInterval
+	}
+
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+
+	if c.BaseBackoff.Duration <= 0 {
+		c.BaseBackoff.Duration = DefaultBaseBackoff
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = DefaultWorkers
+	}
+
+	if c.IndexPattern == "" {
+		c.IndexPattern = DefaultIndexPattern
+	}
+
+	if c.DocType == "" {
+		c.DocType = DefaultDocType
+	}
+
+	if c.EnrichTimeout.Duration <= 0 {
+		c.EnrichTimeout.Duration = DefaultEnrichTimeout
+	}
+
+	if c.EnrichConcurrency <= 0 {
+		c.EnrichConcurrency = DefaultEnrichConcurrency
+	}
+
+	if c.IDField == "" {
+		c.IDField = DefaultIDField
+	}
+
+	if c.DobPolicy == "" {
+		c.DobPolicy = DefaultDobPolicy
+	}
+}
+
+// splitChroot splits Zookeeper chroot, e.g. "/kafka" or "/kafka/", from the address copied from the Zookeeper
+// connection string.
+func splitChroot(addr string) (string, string) {
+	i := strings.Index(addr, "/")
+	if i < 0 {
+		return addr, ""
+	}
+
+	return addr[:i], addr[i:]
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// applyEnvOverrides overrides values read from the config file with environment variables:
+//
+//	TS_BROKERS          - Brokers, comma-separated
+//	TS_TOPICS           - Topics, comma-separated
+//	TS_KAFKA_USERNAME   - KafkaUsername
+//	TS_KAFKA_PASSWORD   - KafkaPassword
+//	TS_ELASTICS         - Elastics, comma-separated
+//	TS_ELASTIC_USER     - ElasticUser
+//	TS_ELASTIC_PASSWORD - ElasticPassword
+//	TS_BULK_SIZE        - BulkSize
+func applyEnvOverrides(c *Config) error {
+	if v, ok := os.LookupEnv("TS_BROKERS"); ok {
+		c.Brokers = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("TS_TOPICS"); ok {
+		c.Topics = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("TS_KAFKA_USERNAME"); ok {
+		c.KafkaUsername = v
+	}
+
+	if v, ok := os.LookupEnv("TS_KAFKA_PASSWORD"); ok {
+		c.KafkaPassword = v
+	}
+
+	if v, ok := os.LookupEnv("TS_ELASTICS"); ok {
+		c.Elastics = splitList(v)
+	}
+
+	if v, ok := os.LookupEnv("TS_ELASTIC_USER"); ok {
+		c.ElasticUser = v
+	}
+
+	if v, ok := os.LookupEnv("TS_ELASTIC_PASSWORD"); ok {
+		c.ElasticPassword = v
+	}
+
+	if v, ok := os.LookupEnv("TS_BULK_SIZE"); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid TS_BULK_SIZE %q: %v", v, err)
+		}
+		c.BulkSize = size
+	}
+
+	return nil
+}
+
+// splitList splits comma-separated list skipping empty elements.
+func splitList(v string) []string {
+	var list []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"io"
+
+	"github.com/BurntSushi/toml"
+)
+
+// redacted replaces secrets in the printed config.
+const redacted = "REDACTED"
+
+// WriteTOML writes the config in the format of the config file with passwords redacted.
+func (c Config) WriteTOML(w io.Writer) error {
+	if c.KafkaPassword != "" {
+		c.KafkaPassword = redacted
+	}
+	if c.ElasticPassword != "" {
+		c.ElasticPassword = redacted
+	}
+
+	return toml.NewEncoder(w).Encode(c)
+}
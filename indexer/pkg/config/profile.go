@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// profiles are optional sections of the config file. Keys of the [default] table override top-level keys, keys of the
+// selected [profiles.<name>] table override both, e.g.:
+//
+//	[default]
+//	Brokers = ["127.0.0.1:9092"]
+//
+//	[profiles.prod]
+//	Brokers = ["kafka-1:9092", "kafka-2:9092"]
+type profiles struct {
+	Default  toml.Primitive
+	Profiles map[string]toml.Primitive
+}
+
+// decodeConfig decodes config file into conf and applies [default] table and the profile on top of it. Profile is not
+// applied when it's empty.
+func decodeConfig(data []byte, conf *Config, profile string) error {
+	if _, err := toml.Decode(string(data), conf); err != nil {
+		return err
+	}
+
+	var p profiles
+	md, err := toml.Decode(string(data), &p)
+	if err != nil {
+		return err
+	}
+
+	if md.IsDefined("default") {
+		if err := md.PrimitiveDecode(p.Default, conf); err != nil {
+			return fmt.Errorf("invalid [default] section: %v", err)
+		}
+	}
+
+	if profile == "" {
+		return nil
+	}
+
+	primitive, ok := p.Profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(p.Profiles))
+		for name := range p.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q not found; available profiles: %s", profile, strings.Join(names, ", "))
+	}
+
+	if err := md.PrimitiveDecode(primitive, conf); err != nil {
+		return fmt.Errorf("invalid [profiles.%s] section: %v", profile, err)
+	}
+
+	return nil
+}
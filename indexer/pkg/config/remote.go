@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// remoteTimeout limits fetching of the config from the URL.
+const remoteTimeout = 10 * time.Second
+
+// readConfig reads config from the file or, when path is an http:// or https:// URL, fetches it. Value of the
+// TS_CONFIG_AUTHORIZATION environment variable is sent as the Authorization header, e.g. "Bearer <token>".
+func readConfig(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return ioutil.ReadFile(path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config URL %q: %v", path, err)
+	}
+	if auth, ok := os.LookupEnv("TS_CONFIG_AUTHORIZATION"); ok {
+		req.Header.Set("Authorization", auth)
+	}
+
+	client := &http.Client{Timeout: remoteTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch config from %s: %v", path, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch config from %s: status %s", path, res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
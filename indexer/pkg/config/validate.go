@@ -0,0 +1,304 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Validate checks that all required fields are set and have valid values.
+func (c *Config) Validate() error {
+	switch c.Source {
+	case "", SourceKafka:
+		if err := c.validateKafka(); err != nil {
+			return err
+		}
+	case SourceFile:
+		if c.FilePath == "" {
+			return fmt.Errorf("invalid config: FilePath can't be empty when reading users from file")
+		}
+		if c.Tail {
+			return fmt.Errorf("invalid config: -tail can't be used when reading users from file")
+		}
+	case SourceCustom:
+		if c.Tail || c.Replay {
+			return fmt.Errorf("invalid config: -tail and -replay can't be used with custom source")
+		}
+	default:
+		return fmt.Errorf("invalid config: source %q must be one of: kafka, file", c.Source)
+	}
+
+	if c.Tail && c.Replay {
+		return fmt.Errorf("invalid config: -tail can't be used with -replay, tail doesn't use consumer group")
+	}
+
+	if c.Tail && c.StartTimestamp != "" {
+		return fmt.Errorf("invalid config: -tail can't be used with StartTimestamp, tail always starts from the newest offset")
+	}
+
+	if c.ReprocessDLQ {
+		if c.DeadLetterTopic == "" {
+			return fmt.Errorf("invalid config: DeadLetterTopic can't be empty with -reprocess-dlq")
+		}
+		if c.Source == SourceFile || c.Source == SourceCustom || c.Tail || c.Replay {
+			return fmt.Errorf("invalid config: -reprocess-dlq can't be used with -tail, -replay, file or custom source")
+		}
+	}
+
+	if c.HTTPPort <= 0 || c.HTTPPort > 65535 {
+		return fmt.Errorf("invalid config: HTTPPort %d must be between 1 and 65535", c.HTTPPort)
+	}
+
+	switch c.Sink {
+	case "", SinkElastic, SinkStdout, SinkCustom:
+	case SinkFile:
+		if c.SinkPath == "" {
+			return fmt.Errorf("invalid config: SinkPath can't be empty when writing users to file")
+		}
+	default:
+		return fmt.Errorf("invalid config: sink %q must be one of: elastic, stdout, file", c.Sink)
+	}
+
+	for i, sink := range c.ExtraSinks {
+		switch sink.Type {
+		case SinkStdout:
+		case SinkFile:
+			if sink.Path == "" {
+				return fmt.Errorf("invalid config: ExtraSinks[%d] Path can't be empty when writing users to file", i)
+			}
+		case SinkKafka:
+			if sink.Topic == "" {
+				return fmt.Errorf("invalid config: ExtraSinks[%d] Topic can't be empty when producing users to kafka", i)
+			}
+			for _, topic := range c.Topics {
+				if sink.Topic == topic {
+					return fmt.Errorf("invalid config: ExtraSinks[%d] Topic %s can't be consumed, users would be indexed in a loop", i, topic)
+				}
+			}
+		default:
+			return fmt.Errorf("invalid config: ExtraSinks[%d] type %q must be one of: kafka, stdout, file", i, sink.Type)
+		}
+
+		switch sink.FailurePolicy {
+		case "", SinkFailureBestEffort, SinkFailureFatal:
+		default:
+			return fmt.Errorf("invalid config: ExtraSinks[%d] FailurePolicy %q must be one of: best-effort, fatal", i, sink.FailurePolicy)
+		}
+	}
+
+	if c.UsesElastic() {
+		if len(c.Elastics) == 0 {
+			return fmt.Errorf("invalid config: Elastics can't be empty")
+		}
+
+		for i, elastic := range c.Elastics {
+			u, err := url.Parse(elastic)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid config: Elastics[%d] %q must be an URL like https://host:9200", i, elastic)
+			}
+		}
+
+		for i, elastic := range c.ReplicaElastics {
+			u, err := url.Parse(elastic)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid config: ReplicaElastics[%d] %q must be an URL like https://host:9200", i, elastic)
+			}
+		}
+	}
+
+	switch c.InitialOffset {
+	case "oldest", "newest":
+	default:
+		return fmt.Errorf("invalid config: InitialOffset %q must be one of: oldest, newest", c.InitialOffset)
+	}
+
+	if c.ReadFromOldest != nil && *c.ReadFromOldest != (c.InitialOffset == "oldest") {
+		return fmt.Errorf("invalid config: ReadFromOldest = %v conflicts with InitialOffset %q, remove ReadFromOldest", *c.ReadFromOldest, c.InitialOffset)
+	}
+
+	if c.StartTimestamp != "" {
+		if _, err := time.Parse(time.RFC3339, c.StartTimestamp); err != nil {
+			return fmt.Errorf("invalid config: StartTimestamp %q must be in RFC3339 format, e.g. 2019-09-01T00:00:00Z", c.StartTimestamp)
+		}
+	}
+
+	if _, err := regexp.Compile(c.SkipEmailPattern); err != nil {
+		return fmt.Errorf("invalid config: SkipEmailPattern %q must be a valid regular expression: %v", c.SkipEmailPattern, err)
+	}
+
+	if len(c.SkipPnumRange) > 0 && (len(c.SkipPnumRange) != 2 || c.SkipPnumRange[0] > c.SkipPnumRange[1]) {
+		return fmt.Errorf("invalid config: SkipPnumRange %v must be a range [from, to]", c.SkipPnumRange)
+	}
+
+	renamed := make(map[string]bool, len(c.RenameFields))
+	for from, to := range c.RenameFields {
+		if from == "" || to == "" || renamed[to] {
+			return fmt.Errorf("invalid config: RenameFields %v must map fields to distinct non-empty names", c.RenameFields)
+		}
+		renamed[to] = true
+	}
+
+	if c.EnrichURL != "" {
+		u, err := url.Parse(c.EnrichURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid config: EnrichURL %q must be an URL like http://host:8080/enrich", c.EnrichURL)
+		}
+	}
+
+	// tombstones are not redacted, deletes of users keyed by a redacted field would never match their documents
+	for _, field := range c.RedactFields {
+		if field == c.IDField || field == c.RoutingField || field == c.IndexField {
+			return fmt.Errorf("invalid config: RedactFields can't contain %q, it's used as IDField, RoutingField or IndexField", field)
+		}
+	}
+
+	switch c.RedactMode {
+	case "", RedactModeHash, RedactModeMask:
+	default:
+		return fmt.Errorf("invalid config: RedactMode %q must be one of: hash, mask", c.RedactMode)
+	}
+
+	switch c.RefreshPolicy {
+	case "", "false", "wait_for", "true":
+	default:
+		return fmt.Errorf("invalid config: RefreshPolicy %q must be one of: false, wait_for, true", c.RefreshPolicy)
+	}
+
+	if c.DedupeBatch && c.UpdateMode {
+		return fmt.Errorf("invalid config: DedupeBatch can't be used with UpdateMode, partial updates can't be collapsed")
+	}
+
+	if c.IndexShards < 0 {
+		return fmt.Errorf("invalid config: IndexShards %d can't be negative", c.IndexShards)
+	}
+
+	if c.IndexReplicas != nil && *c.IndexReplicas < 0 {
+		return fmt.Errorf("invalid config: IndexReplicas %d can't be negative", *c.IndexReplicas)
+	}
+
+	switch c.CommitStrategy {
+	case "", CommitAfterEnqueue, CommitAfterIndex:
+	default:
+		return fmt.Errorf("invalid config: CommitStrategy %q must be one of: after-enqueue, after-index", c.CommitStrategy)
+	}
+
+	switch c.ShardBy {
+	case "", ShardByPartition, ShardByID:
+	default:
+		return fmt.Errorf("invalid config: ShardBy %q must be one of: partition, id", c.ShardBy)
+	}
+
+	if (c.IndexField != "") != strings.Contains(c.IndexPattern, "%f") {
+		return fmt.Errorf("invalid config: IndexField must be set together with %%f placeholder in IndexPattern %q", c.IndexPattern)
+	}
+
+	if strings.Contains(c.WriteAlias, "%") {
+		return fmt.Errorf("invalid config: WriteAlias %q can't contain placeholders", c.WriteAlias)
+	}
+
+	if c.WriteAliasIndex != "" && c.WriteAlias == "" {
+		return fmt.Errorf("invalid config: WriteAliasIndex can't be set without WriteAlias")
+	}
+
+	switch c.IndexDateField {
+	case "", "now", "dob":
+	default:
+		return fmt.Errorf("invalid config: IndexDateField %q must be one of: now, dob", c.IndexDateField)
+	}
+
+	switch c.DobPolicy {
+	case "nullify", "drop":
+	case "default":
+		if _, err := time.Parse("2006-01-02", c.DobDefault); err != nil {
+			return fmt.Errorf("invalid config: DobDefault %q must be a date in YYYY-MM-DD format", c.DobDefault)
+		}
+	default:
+		return fmt.Errorf("invalid config: DobPolicy %q must be one of: nullify, drop, default", c.DobPolicy)
+	}
+
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("invalid config: RetentionDays %d can't be negative", c.RetentionDays)
+	}
+
+	if c.RetentionDays > 0 && c.RetentionField == "" {
+		return fmt.Errorf("invalid config: RetentionField can't be empty when RetentionDays is set")
+	}
+
+	if c.TracingSampleRatio > 1 {
+		return fmt.Errorf("invalid config: TracingSampleRatio %v must be between 0 and 1", c.TracingSampleRatio)
+	}
+
+	if (c.ElasticClientCert == "") != (c.ElasticClientKey == "") {
+		return fmt.Errorf("invalid config: ElasticClientCert and ElasticClientKey must be set together")
+	}
+
+	return nil
+}
+
+// clientIDPattern is the format of client ids accepted by sarama.
+var clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (c *Config) validateKafka() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("invalid config: Brokers can't be empty")
+	}
+
+	for i, broker := range c.Brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return fmt.Errorf("invalid config: Brokers[%d] %q must be in host:port format: %v", i, broker, err)
+		}
+	}
+
+	switch c.MessageFormat {
+	case "", MessageFormatJSON, MessageFormatProtobuf:
+	case MessageFormatAvro:
+		u, err := url.Parse(c.SchemaRegistryURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid config: SchemaRegistryURL %q must be an URL like http://host:8081 for avro messages", c.SchemaRegistryURL)
+		}
+	default:
+		return fmt.Errorf("invalid config: MessageFormat %q must be one of: json, avro, protobuf", c.MessageFormat)
+	}
+
+	if !clientIDPattern.MatchString(c.ClientID) {
+		return fmt.Errorf("invalid config: ClientID %q may contain only letters, digits, '.', '_' and '-'", c.ClientID)
+	}
+
+	if strings.TrimSpace(c.ConsumerGroup) == "" {
+		return fmt.Errorf("invalid config: ConsumerGroup can't be empty")
+	}
+
+	if len(c.Topics) == 0 {
+		return fmt.Errorf("invalid config: Topics (or Topic) can't be empty")
+	}
+
+	for i, topic := range c.Topics {
+		if topic == "" {
+			return fmt.Errorf("invalid config: Topics[%d] can't be empty", i)
+		}
+		if c.RetryTopic != "" && topic == c.RetryTopic {
+			return fmt.Errorf("invalid config: RetryTopic %q can't be one of Topics", c.RetryTopic)
+		}
+	}
+
+	seen := make(map[int32]bool, len(c.Partitions))
+	for _, partition := range c.Partitions {
+		if partition < 0 || seen[partition] {
+			return fmt.Errorf("invalid config: Partitions %v must be distinct non-negative partitions", c.Partitions)
+		}
+		seen[partition] = true
+	}
+
+	if c.RetryTopic != "" && len(c.Partitions) > 0 {
+		return fmt.Errorf("invalid config: RetryTopic can't be used with Partitions")
+	}
+
+	if c.RetryTopic != "" && c.RetryTopic == c.DeadLetterTopic {
+		return fmt.Errorf("invalid config: RetryTopic and DeadLetterTopic can't be the same topic %q", c.RetryTopic)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns config decoded from the minimal valid config file followed by extra lines.
+func validConfig(t *testing.T, extra ...string) *Config {
+	t.Helper()
+
+	data := strings.Join(append([]string{
+		`Brokers = [ "127.0.0.1:9092" ]`,
+		`Topics = [ "users" ]`,
+		`HTTPPort = 8080`,
+		`Elastics = [ "http://127.0.0.1:9200" ]`,
+		`IndexPattern = "users"`,
+	}, extra...), "\n")
+
+	var conf Config
+	if err := decodeConfig([]byte(data), &conf, ""); err != nil {
+		t.Fatalf("can't decode config: %v", err)
+	}
+	conf.SetDefaults()
+	return &conf
+}
+
+func TestReadFromOldest(t *testing.T) {
+	tests := []struct {
+		name          string
+		extra         []string
+		initialOffset string
+		err           string
+	}{
+		{name: "default", initialOffset: "oldest"},
+		{name: "read from oldest", extra: []string{"ReadFromOldest = true"}, initialOffset: "oldest"},
+		{name: "read from newest", extra: []string{"ReadFromOldest = false"}, initialOffset: "newest"},
+		{name: "initial offset wins", extra: []string{`InitialOffset = "newest"`}, initialOffset: "newest"},
+		{name: "same offset", extra: []string{"ReadFromOldest = false", `InitialOffset = "newest"`}, initialOffset: "newest"},
+		{name: "conflict", extra: []string{"ReadFromOldest = false", `InitialOffset = "oldest"`}, initialOffset: "oldest", err: "conflicts with InitialOffset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t, tt.extra...)
+			if conf.InitialOffset != tt.initialOffset {
+				t.Errorf("InitialOffset = %q, want %q", conf.InitialOffset, tt.initialOffset)
+			}
+
+			err := conf.Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error = %v, want error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBrokersChroot(t *testing.T) {
+	tests := []struct {
+		name    string
+		brokers []string
+		want    []string
+		err     bool
+	}{
+		{name: "no chroot", brokers: []string{"kafka-0:9092", "kafka-1:9092"}, want: []string{"kafka-0:9092", "kafka-1:9092"}},
+		{name: "chroot", brokers: []string{"kafka-0:9092/kafka"}, want: []string{"kafka-0:9092"}},
+		{name: "chroot with trailing slash", brokers: []string{"kafka-0:9092/kafka/"}, want: []string{"kafka-0:9092"}},
+		{name: "nested chroot", brokers: []string{"kafka-0:9092/env/kafka", "kafka-1:9092/env/kafka/"}, want: []string{"kafka-0:9092", "kafka-1:9092"}},
+		{name: "chroot without address", brokers: []string{"/kafka"}, want: []string{""}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t)
+			conf.Brokers = tt.brokers
+			conf.SetDefaults()
+			if strings.Join(conf.Brokers, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Brokers = %q, want %q", conf.Brokers, tt.want)
+			}
+
+			if err := conf.Validate(); (err != nil) != tt.err {
+				t.Errorf("err = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEnrichURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		url    string
+		err    bool
+	}{
+		{name: "disabled", source: SourceKafka},
+		{name: "valid", source: SourceKafka, url: "http://enrich:8080/enrich"},
+		{name: "invalid", source: SourceKafka, url: "enrich:8080", err: true},
+		{name: "valid with file source", source: SourceFile, url: "http://enrich:8080/enrich"},
+		{name: "invalid with file source", source: SourceFile, url: "/enrich", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t, `FilePath = "users.json"`)
+			conf.Source = tt.source
+			conf.EnrichURL = tt.url
+
+			if err := conf.Validate(); (err != nil) != tt.err {
+				t.Errorf("err = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		name           string
+		source         string
+		replay         bool
+		startTimestamp string
+		err            bool
+	}{
+		{name: "tail", source: SourceKafka},
+		{name: "tail with file source", source: SourceFile, err: true},
+		{name: "tail with replay", source: SourceKafka, replay: true, err: true},
+		{name: "tail with start timestamp", source: SourceKafka, startTimestamp: "2019-09-01T00:00:00Z", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t, `FilePath = "users.json"`)
+			conf.Tail = true
+			conf.Source = tt.source
+			conf.Replay = tt.replay
+			conf.StartTimestamp = tt.startTimestamp
+
+			if err := conf.Validate(); (err != nil) != tt.err {
+				t.Errorf("err = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRedactKeyFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		extra  []string
+		fields []string
+		err    bool
+	}{
+		{name: "text fields", fields: []string{"Email", "Nickname"}},
+		{name: "id field", extra: []string{`IDField = "Email"`}, fields: []string{"Email"}, err: true},
+		{name: "routing field", extra: []string{`RoutingField = "City"`}, fields: []string{"Email", "City"}, err: true},
+		{name: "index field", extra: []string{`IndexField = "City"`}, fields: []string{"City"}, err: true},
+		{name: "other key field", extra: []string{`IndexField = "City"`}, fields: []string{"Email"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t, tt.extra...)
+			if conf.IndexField != "" {
+				conf.IndexPattern = "users-%f"
+			}
+			conf.RedactFields = tt.fields
+
+			err := conf.Validate()
+			if (err != nil) != tt.err || err != nil && !strings.Contains(err.Error(), "RedactFields") {
+				t.Errorf("err = %v, want RedactFields error: %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCommitInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		extra    []string
+		interval time.Duration
+	}{
+		{name: "default", interval: DefaultCommitInterval},
+		{name: "configured", extra: []string{`CommitInterval = "1s"`}, interval: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t, tt.extra...)
+			if conf.CommitInterval.Duration != tt.interval {
+				t.Errorf("CommitInterval = %v, want %v", conf.CommitInterval.Duration, tt.interval)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// SwitchAlias atomically points the WriteAlias to the index, creating the index with users mapping if it doesn't
+// exist. Alias is removed from all other indices in the same request, so users are never written to two indices.
+// Failure on the replica cluster is only logged.
+func (p *Indexer) SwitchAlias(ctx context.Context, index string) error {
+	if p.cfg.WriteAlias == "" {
+		return fmt.Errorf("WriteAlias is not set")
+	}
+	if p.esClient == nil {
+		return fmt.Errorf("elasticsearch sink is disabled")
+	}
+
+	if err := p.switchAlias(ctx, p.esClient, index); err != nil {
+		return err
+	}
+
+	if p.replicaClient != nil {
+		if err := p.switchAlias(ctx, p.replicaClient, index); err != nil {
+			p.replicaErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+			log.Errorf("can't switch alias on replica cluster. Err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Indexer) switchAlias(ctx context.Context, client *elastic.Client, index string) error {
+	alias := p.cfg.WriteAlias
+	if err := p.createIndex(ctx, client, index); err != nil {
+		return err
+	}
+
+	current, err := p.aliasIndices(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	update := client.Alias().Add(index, alias)
+	for _, name := range current {
+		if name == index {
+			continue
+		}
+		update.Remove(name, alias)
+	}
+
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+	if _, err := update.Do(reqCtx); err != nil {
+		return fmt.Errorf("can't point alias %s to %s. err: %v", alias, index, err)
+	}
+
+	log.Infof("Alias %s points to %s, previously %v", alias, index, current)
+	return nil
+}
+
+// ensureAlias checks that the WriteAlias exists before users are written through it. Writes to missing alias would
+// create a regular index with its name.
+func (p *Indexer) ensureAlias(ctx context.Context) error {
+	if p.cfg.WriteAliasIndex != "" {
+		return p.SwitchAlias(ctx, p.cfg.WriteAliasIndex)
+	}
+
+	current, err := p.aliasIndices(ctx, p.esClient)
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		return fmt.Errorf("alias %s doesn't exist, set WriteAliasIndex to create it", p.cfg.WriteAlias)
+	}
+
+	log.Infof("Alias %s points to %v", p.cfg.WriteAlias, current)
+	return nil
+}
+
+// aliasIndices returns indices the WriteAlias points to.
+func (p *Indexer) aliasIndices(ctx context.Context, client *elastic.Client) ([]string, error) {
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	res, err := client.Aliases().Alias(p.cfg.WriteAlias).Do(reqCtx)
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get indices of alias %s. err: %v", p.cfg.WriteAlias, err)
+	}
+
+	return res.IndicesByAlias(p.cfg.WriteAlias), nil
+}
@@ -0,0 +1,242 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// schemaRegistryTimeout timeout of requests to the schema registry.
+const schemaRegistryTimeout = 10 * time.Second
+
+// avroSchema is a schema fetched from the registry.
+type avroSchema struct {
+	codec  *goavro.Codec
+	schema interface{}
+	// named types of the schema by their full and short names
+	named map[string]interface{}
+}
+
+// schemaRegistry decodes Avro messages in the Confluent wire format: magic byte, 4 bytes of schema id and Avro binary.
+// Schemas are fetched from the registry once and cached by id.
+type schemaRegistry struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	schemas map[uint32]*avroSchema
+}
+
+func newSchemaRegistry(url string) *schemaRegistry {
+	return &schemaRegistry{
+		url:     strings.TrimSuffix(url, "/"),
+		client:  &http.Client{Timeout: schemaRegistryTimeout},
+		schemas: make(map[uint32]*avroSchema),
+	}
+}
+
+// unmarshal decodes Avro message into v. Message is converted to JSON first so v is decoded with the same rules as
+// JSON messages.
+func (r *schemaRegistry) unmarshal(data []byte, v interface{}) error {
+	if len(data) < 5 || data[0] != 0 {
+		return fmt.Errorf("message is not in the schema registry wire format")
+	}
+
+	schema, err := r.schema(binary.BigEndian.Uint32(data[1:5]))
+	if err != nil {
+		return err
+	}
+
+	native, _, err := schema.codec.NativeFromBinary(data[5:])
+	if err != nil {
+		return fmt.Errorf("can't decode avro message. err: %v", err)
+	}
+
+	doc, err := json.Marshal(avroPlain(schema.schema, native, schema.named))
+	if err != nil {
+		return fmt.Errorf("can't convert avro message to json. err: %v", err)
+	}
+
+	return json.Unmarshal(doc, v)
+}
+
+func (r *schemaRegistry) schema(id uint32) (*avroSchema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if schema, ok := r.schemas[id]; ok {
+		return schema, nil
+	}
+
+	res, err := r.client.Get(fmt.Sprintf("%s/schemas/ids/%d", r.url, id))
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch schema %d. err: %v", id, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't fetch schema %d. status: %s", id, res.Status)
+	}
+
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("can't decode schema %d. err: %v", id, err)
+	}
+
+	codec, err := goavro.NewCodec(body.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %d. err: %v", id, err)
+	}
+
+	schema := &avroSchema{codec: codec, named: make(map[string]interface{})}
+	if err := json.Unmarshal([]byte(body.Schema), &schema.schema); err != nil {
+		return nil, fmt.Errorf("invalid schema %d. err: %v", id, err)
+	}
+	collectNamed(schema.schema, "", schema.named)
+
+	r.schemas[id] = schema
+
+	return schema, nil
+}
+
+// collectNamed registers records, enums and fixed types of the schema, so they can be found when referenced by name.
+func collectNamed(schema interface{}, namespace string, named map[string]interface{}) {
+	switch s := schema.(type) {
+	case []interface{}:
+		for _, member := range s {
+			collectNamed(member, namespace, named)
+		}
+	case map[string]interface{}:
+		if name, ok := s["name"].(string); ok {
+			if ns, ok := s["namespace"].(string); ok {
+				namespace = ns
+			}
+			named[name] = s
+			if namespace != "" && !strings.Contains(name, ".") {
+				named[namespace+"."+name] = s
+			}
+		}
+
+		if fields, ok := s["fields"].([]interface{}); ok {
+			for _, field := range fields {
+				if f, ok := field.(map[string]interface{}); ok {
+					collectNamed(f["type"], namespace, named)
+				}
+			}
+		}
+
+		for _, key := range []string{"type", "items", "values"} {
+			if t, ok := s[key]; ok {
+				if _, isString := t.(string); !isString {
+					collectNamed(t, namespace, named)
+				}
+			}
+		}
+	}
+}
+
+// avroPlain converts value decoded by goavro to a value which encodes to plain JSON. goavro decodes unions as
+// single-key maps with the name of the union member, those are replaced with the member value.
+func avroPlain(schema interface{}, value interface{}, named map[string]interface{}) interface{} {
+	switch s := schema.(type) {
+	case string:
+		if def, ok := named[s]; ok {
+			return avroPlain(def, value, named)
+		}
+	case []interface{}:
+		m, ok := value.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return value
+		}
+
+		for member, v := range m {
+			for _, branch := range s {
+				name := avroTypeName(branch)
+				if member == name || strings.HasSuffix(member, "."+name) {
+					return avroPlain(branch, v, named)
+				}
+			}
+			return v
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record", "error":
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return value
+			}
+
+			fields, _ := s["fields"].([]interface{})
+			out := make(map[string]interface{}, len(m))
+			for _, field := range fields {
+				f, _ := field.(map[string]interface{})
+				name, _ := f["name"].(string)
+				if v, ok := m[name]; ok {
+					out[name] = avroPlain(f["type"], v, named)
+				}
+			}
+			return out
+		case "array":
+			a, ok := value.([]interface{})
+			if !ok {
+				return value
+			}
+
+			out := make([]interface{}, len(a))
+			for i, v := range a {
+				out[i] = avroPlain(s["items"], v, named)
+			}
+			return out
+		case "map":
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return value
+			}
+
+			out := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				out[k] = avroPlain(s["values"], v, named)
+			}
+			return out
+		case "enum", "fixed":
+			return value
+		}
+
+		// dates are sent as date logical type, they are indexed in the same format as in JSON messages
+		if t, ok := value.(time.Time); ok && s["logicalType"] == "date" {
+			return t.Format(dobLayout)
+		}
+
+		return avroPlain(s["type"], value, named)
+	}
+
+	return value
+}
+
+// avroTypeName returns name of the union member as used by goavro.
+func avroTypeName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case map[string]interface{}:
+		if name, ok := s["name"].(string); ok {
+			return name
+		}
+		if t, ok := s["type"].(string); ok {
+			if logical, ok := s["logicalType"].(string); ok {
+				return t + "." + logical
+			}
+			return t
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,48 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// backpressureCheckInterval how often fill level of the users channel is checked.
+	backpressureCheckInterval = 5 * time.Second
+	// backpressureWarnAfter how long channel has to stay near full before warning is logged.
+	backpressureWarnAfter = 30 * time.Second
+	// nearFullRatio fill level of the channel considered as near full.
+	nearFullRatio = 0.9
+)
+
+// watchBackpressure warns when users channel stays near full, which means indexing can't keep up with consuming.
+func watchBackpressure(ctx context.Context, users chan userEvent) {
+	ticker := time.NewTicker(backpressureCheckInterval)
+	defer ticker.Stop()
+
+	var nearFullSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if float64(len(users)) < nearFullRatio*float64(cap(users)) {
+				nearFullSince = time.Time{}
+				continue
+			}
+
+			if nearFullSince.IsZero() {
+				nearFullSince = now
+				continue
+			}
+
+			if now.Sub(nearFullSince) >= backpressureWarnAfter {
+				log.Warnf("Users channel is near full (%d/%d) for %v, indexing is the bottleneck",
+					len(users), cap(users), now.Sub(nearFullSince).Round(time.Second))
+				// warn again after the next period
+				nearFullSince = now
+			}
+		}
+	}
+}
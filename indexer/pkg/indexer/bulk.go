@@ -0,0 +1,645 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// elasticSink indexes users in Elasticsearch with configured number of workers, each with its own bulk request.
+type elasticSink struct {
+	// single channel shared by all workers or one channel per worker when users are sharded
+	events  []chan userEvent
+	shardBy func(event userEvent) uint32
+	// flush requests of every worker, request is closed once the worker flushes its bulk
+	flushes []chan chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (p *Indexer) newElasticSink(ctx context.Context) *elasticSink {
+	s := &elasticSink{events: []chan userEvent{make(chan userEvent)}}
+
+	switch p.cfg.ShardBy {
+	case config.ShardByPartition:
+		s.shardBy = func(event userEvent) uint32 { return uint32(event.partition) }
+	case config.ShardByID:
+		s.shardBy = func(event userEvent) uint32 {
+			id, _ := p.documentID(event.User)
+			return hashKey(id)
+		}
+	default:
+		// users with the same routing are stored in the same shard, bulk of the worker then goes to fewer shards
+		if p.cfg.RoutingField != "" && p.cfg.Workers > 1 {
+			s.shardBy = func(event userEvent) uint32 { return hashKey(p.routing(event.User)) }
+		}
+	}
+
+	if s.shardBy != nil {
+		s.events = make([]chan userEvent, p.cfg.Workers)
+		for i := range s.events {
+			s.events[i] = make(chan userEvent)
+		}
+	}
+
+	for i := 0; i < p.cfg.Workers; i++ {
+		events := s.events[i%len(s.events)]
+		flushes := make(chan chan struct{})
+		s.flushes = append(s.flushes, flushes)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			p.indexWorker(ctx, events, flushes)
+		}()
+	}
+
+	return s
+}
+
+// hashKey returns FNV-1a hash of the sharding key.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Index passes user to the first free worker or to the worker of its shard.
+func (s *elasticSink) Index(event userEvent) error {
+	if s.shardBy == nil {
+		s.events[0] <- event
+		return nil
+	}
+
+	s.events[s.shardBy(event)%uint32(len(s.events))] <- event
+	return nil
+}
+
+// Flush waits until all workers flush their bulk requests.
+func (s *elasticSink) Flush() error {
+	done := make([]chan struct{}, len(s.flushes))
+	for i, flushes := range s.flushes {
+		done[i] = make(chan struct{})
+		flushes <- done[i]
+	}
+	for _, d := range done {
+		<-d
+	}
+	return nil
+}
+
+// Close waits until all workers flush their bulk requests.
+func (s *elasticSink) Close() error {
+	for _, events := range s.events {
+		close(events)
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// documentID returns Elasticsearch _id of the user taken from the IDField. Second value is false when the field is empty.
+func (p *Indexer) documentID(user models.User) (string, bool) {
+	return userField(user, p.cfg.IDField)
+}
+
+// routing returns routing value of the user taken from the RoutingField. Empty routing means default routing by _id.
+func (p *Indexer) routing(user models.User) string {
+	if p.cfg.RoutingField == "" {
+		return ""
+	}
+
+	routing, _ := userField(user, p.cfg.RoutingField)
+	return routing
+}
+
+// indexWorker drains users channel into its own bulk request. Remaining actions are flushed when channel is closed or
+// when flush is requested.
+func (p *Indexer) indexWorker(ctx context.Context, users chan userEvent, flushes chan chan struct{}) {
+	bulkSize := p.cfg.BulkSize
+
+	// flush partial bulks periodically so users don't wait for a full bulk during low traffic
+	ticker := time.NewTicker(p.cfg.FlushInterval.Duration)
+	defer ticker.Stop()
+
+	var batch []bulkItem
+	// estimated size of documents in the bulk request
+	var bulkBytes int
+	// users rejected with retryable errors are sent again with the next bulk
+	flush := func() {
+		var retry []bulkItem
+		if p.cfg.DedupeBatch {
+			batch = p.dedupe(batch)
+		}
+		if len(batch) > 0 {
+			retry = p.flush(ctx, batch)
+		}
+		batch = retry
+		bulkBytes = 0
+		for _, item := range retry {
+			bulkBytes += len(item.doc)
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-users:
+			if !ok {
+				// retries of every user are limited, so the last bulk is eventually empty
+				for flush(); len(batch) > 0; flush() {
+					time.Sleep(jitter(p.cfg.BaseBackoff.Duration))
+				}
+				return
+			}
+
+			id, ok := p.documentID(event.User)
+			if !ok {
+				p.skipped.WithLabelValues("empty_id").Inc()
+				atomic.AddInt64(&p.counters.done, 1)
+				event.ack.ack()
+				log.Warnf("user without %s skipped, it would overwrite other users with empty id", p.cfg.IDField)
+				continue
+			}
+
+			index := p.indexName(event.User)
+			if err := p.ensureIndex(ctx, index); err != nil {
+				log.Fatalf("Can't prepare index. Err: %v", err)
+			}
+
+			routing := p.routing(event.User)
+			key := index + "/" + id
+
+			// deletes and index requests can be mixed in the same bulk
+			if event.Deleted {
+				p.unchanged.forget(key)
+				batch = append(batch, bulkItem{request: p.deleteRequest(index, id, routing, event.Version), index: index, id: id, pnum: event.Pnum, span: event.spanContext, ack: event.ack})
+			} else {
+				now := time.Now()
+				doc, err := p.marshalDocument(event.User, now)
+				if err != nil {
+					p.skipped.WithLabelValues("marshal").Inc()
+					atomic.AddInt64(&p.counters.done, 1)
+					event.ack.ack()
+					log.Errorf("can't marshal user with id: %s. Err: %v", id, err)
+					continue
+				}
+
+				doc = p.enrich(ctx, id, event.User, doc)
+
+				// timestamp of indexing is not a part of the hash, it differs even for unchanged users
+				var hash docHash
+				if p.unchanged != nil {
+					hash = hashDocument(doc)
+					if p.unchanged.unchanged(key, hash) {
+						p.unchangedCache.WithLabelValues("hit").Inc()
+						p.skipped.WithLabelValues("unchanged").Inc()
+						atomic.AddInt64(&p.counters.done, 1)
+						event.ack.ack()
+						continue
+					}
+					p.unchangedCache.WithLabelValues("miss").Inc()
+					// hash is stored once the document is indexed, pending update can't be skipped by the older one
+					p.unchanged.forget(key)
+				}
+
+				if doc, err = p.withIndexedAt(doc, now); err != nil {
+					p.skipped.WithLabelValues("marshal").Inc()
+					atomic.AddInt64(&p.counters.done, 1)
+					event.ack.ack()
+					log.Errorf("can't add %s to user with id: %s. Err: %v", p.cfg.IndexedAtField, id, err)
+					continue
+				}
+
+				if p.oversized(id, doc) {
+					event.ack.ack()
+					continue
+				}
+
+				// flush before the bulk request grows over the limit
+				if p.cfg.MaxBulkBytes > 0 && bulkBytes+len(doc) > p.cfg.MaxBulkBytes {
+					flush()
+				}
+
+				batch = append(batch, bulkItem{request: p.bulkableRequest(index, id, routing, event.Version, doc), index: index, id: id, pnum: event.Pnum, doc: doc, hash: hash, span: event.spanContext, ack: event.ack})
+				bulkBytes += len(doc)
+			}
+
+			atomic.AddInt64(&p.counters.enqued, 1)
+
+			if len(batch) >= bulkSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-flushes:
+			flush()
+			close(done)
+		}
+	}
+}
+
+// oversized skips user which document is bigger than MaxDocBytes, it would fail every bulk request it's part of.
+// Document is sent to the dead letter topic.
+func (p *Indexer) oversized(id string, doc []byte) bool {
+	if p.cfg.MaxDocBytes <= 0 || len(doc) <= p.cfg.MaxDocBytes {
+		return false
+	}
+
+	p.skipped.WithLabelValues("oversized").Inc()
+	atomic.AddInt64(&p.counters.done, 1)
+	log.Warnf("user with id: %s skipped, document has %d bytes, more than %d allowed", id, len(doc), p.cfg.MaxDocBytes)
+
+	reason := fmt.Errorf("document has %d bytes, more than %d allowed", len(doc), p.cfg.MaxDocBytes)
+	if err := p.deadLetter.sendDocument(id, doc, reason); err != nil {
+		log.Error(err)
+	}
+
+	return true
+}
+
+// dedupe removes all but the last update of every document from the batch, order of the remaining updates is kept.
+func (p *Indexer) dedupe(batch []bulkItem) []bulkItem {
+	seen := make(map[string]bool, len(batch))
+	kept := make([]bulkItem, len(batch))
+	n := len(batch)
+	for i := len(batch) - 1; i >= 0; i-- {
+		key := batch[i].index + "/" + batch[i].id
+		if seen[key] {
+			batch[i].ack.ack()
+			continue
+		}
+		seen[key] = true
+		n--
+		kept[n] = batch[i]
+	}
+
+	// n is the number of removed duplicates
+	if n > 0 {
+		p.skipped.WithLabelValues("duplicate").Add(float64(n))
+		atomic.AddInt64(&p.counters.done, int64(n))
+		log.Debugf("%d duplicate updates removed from bulk", n)
+	}
+
+	return kept[n:]
+}
+
+// bulkableRequest creates request indexing the document. In update mode document is merged with the existing one.
+// Ingest pipeline and external versioning are applied to index requests only as Elasticsearch doesn't support them
+// for updates. Version is not checked when it's zero.
+func (p *Indexer) bulkableRequest(index, id, routing string, version int64, doc json.RawMessage) elastic.BulkableRequest {
+	if p.cfg.UpdateMode {
+		return elastic.NewBulkUpdateRequest().
+			Index(index).
+			Type(p.cfg.DocType).
+			Id(id).
+			Routing(routing).
+			Doc(doc).
+			DocAsUpsert(true)
+	}
+
+	request := elastic.NewBulkIndexRequest().
+		Index(index).
+		Type(p.cfg.DocType).
+		Id(id).
+		Routing(routing).
+		Pipeline(p.cfg.Pipeline).
+		Doc(doc)
+	if version > 0 {
+		request.Version(version).VersionType("external")
+	}
+
+	return request
+}
+
+// deleteRequest creates request deleting the document. Version is not checked when it's zero.
+func (p *Indexer) deleteRequest(index, id, routing string, version int64) elastic.BulkableRequest {
+	request := elastic.NewBulkDeleteRequest().
+		Index(index).
+		Type(p.cfg.DocType).
+		Id(id).
+		Routing(routing)
+	if version > 0 {
+		request.Version(version).VersionType("external")
+	}
+
+	return request
+}
+
+// bulkItem is a single action of the bulk request.
+type bulkItem struct {
+	request elastic.BulkableRequest
+	index   string
+	id      string
+	pnum    int64
+	// doc is empty for deletes
+	doc json.RawMessage
+	// hash of the document without IndexedAtField, set only when UnchangedCacheSize is set
+	hash docHash
+	// span of consuming the user
+	span trace.SpanContext
+	// number of times user was rejected with retryable error
+	attempts int
+	// ack marks offset of the message once user is indexed or dead-lettered
+	ack ackFunc
+}
+
+// flush executes bulk request retrying it with exponential backoff. Requests aborted on shutdown are sent again by the
+// next attempt. When all retries fail while the cluster is healthy, the batch is bisected to find the user failing
+// whole request, otherwise the indexer exits. It returns users rejected with retryable errors.
+func (p *Indexer) flush(ctx context.Context, batch []bulkItem) []bulkItem {
+	links := make([]trace.Link, 0, len(batch))
+	for _, item := range batch {
+		if item.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: item.span})
+		}
+	}
+	ctx, span := tracer().Start(ctx, "bulk",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.Int("bulk.documents", len(batch)),
+			attribute.String("bulk.index_pattern", p.cfg.IndexPattern),
+		),
+	)
+	defer span.End()
+
+	// replica gets the same users concurrently, its failures don't affect the primary cluster
+	if p.replicaClient != nil {
+		// requests cache their source on first use, build it before it's shared by both clients
+		for _, item := range batch {
+			item.request.Source()
+		}
+
+		replicaDone := make(chan struct{})
+		go func() {
+			defer close(replicaDone)
+			p.replicate(ctx, batch)
+		}()
+		defer func() { <-replicaDone }()
+	}
+
+	var res *elastic.BulkResponse
+	err := retry("bulk request", p.cfg.MaxRetries, p.cfg.BaseBackoff.Duration, func() error {
+		var err error
+		res, err = p.doBulk(ctx, batch)
+		return err
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "bulk request failed")
+		if !p.elasticHealthy(ctx) {
+			log.Fatalf("Can't execute bulk after %d retries. Err: %v", p.cfg.MaxRetries, err)
+		}
+
+		log.Warnf("Bulk with %d users failed after %d retries while cluster is healthy, bisecting it. Err: %v",
+			len(batch), p.cfg.MaxRetries, err)
+		return p.bisect(ctx, batch, err)
+	}
+
+	return p.bulkDone(res, batch)
+}
+
+// bisect splits failed batch in halves and sends them again until the failing user is found and quarantined. Every
+// half is sent once so the number of requests is bounded by twice the batch size. It returns users rejected with
+// retryable errors.
+func (p *Indexer) bisect(ctx context.Context, batch []bulkItem, err error) []bulkItem {
+	if len(batch) == 1 {
+		p.quarantine(batch[0], err)
+		return nil
+	}
+
+	var retry []bulkItem
+	half := len(batch) / 2
+	for _, part := range [][]bulkItem{batch[:half], batch[half:]} {
+		res, err := p.doBulk(ctx, part)
+		if err != nil {
+			log.Warnf("Part of bulk with %d users (%s..%s) failed. Err: %v", len(part), part[0].id, part[len(part)-1].id, err)
+			retry = append(retry, p.bisect(ctx, part, err)...)
+			continue
+		}
+
+		retry = append(retry, p.bulkDone(res, part)...)
+	}
+
+	return retry
+}
+
+// quarantine moves user failing whole bulk request to the dead letter topic.
+func (p *Indexer) quarantine(item bulkItem, reason error) {
+	p.skipped.WithLabelValues("poison").Inc()
+	p.indexedErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+	atomic.AddInt64(&p.counters.failed, 1)
+	atomic.AddInt64(&p.counters.done, 1)
+	log.Errorf("user with id: %s fails whole bulk request, moving it to dead letter topic. Err: %v", item.id, reason)
+
+	if err := p.deadLetter.sendDocument(item.id, item.doc, reason); err != nil {
+		log.Error(err)
+	}
+	item.ack.ack()
+}
+
+// doBulk sends single bulk request with the batch. Consecutive failures mark Elasticsearch as not ready.
+func (p *Indexer) doBulk(ctx context.Context, batch []bulkItem) (*elastic.BulkResponse, error) {
+	bulkRequest := p.esClient.Bulk().Refresh(p.cfg.RefreshPolicy)
+	for _, item := range batch {
+		bulkRequest.Add(item.request)
+	}
+
+	// workers wait for a free slot when MaxInFlightBulks requests are already executed
+	if p.inFlight != nil {
+		p.inFlight <- struct{}{}
+		defer func() { <-p.inFlight }()
+	}
+	atomic.AddInt64(&p.counters.inFlight, 1)
+	defer atomic.AddInt64(&p.counters.inFlight, -1)
+
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	started := time.Now()
+	res, err := bulkRequest.Do(reqCtx)
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	p.bulkDuration.WithLabelValues(status).Observe(time.Since(started).Seconds())
+	if err != nil {
+		p.bulkErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+		if atomic.AddInt32(&p.bulkFailures, 1) >= unreadyAfterBulkFailures {
+			atomic.StoreInt32(&p.elasticReady, 0)
+		}
+		return nil, err
+	}
+
+	atomic.StoreInt32(&p.bulkFailures, 0)
+	atomic.StoreInt32(&p.elasticReady, 1)
+	return res, nil
+}
+
+// replicate sends the batch to the replica cluster once. Failures are only logged and counted.
+func (p *Indexer) replicate(ctx context.Context, batch []bulkItem) {
+	bulkRequest := p.replicaClient.Bulk().Refresh(p.cfg.RefreshPolicy)
+	for _, item := range batch {
+		bulkRequest.Add(item.request)
+	}
+
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	res, err := bulkRequest.Do(reqCtx)
+	if err != nil {
+		p.replicaErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+		log.Errorf("can't execute bulk with %d users on replica cluster. Err: %v", len(batch), err)
+		return
+	}
+
+	if failed := len(res.Failed()); failed > 0 {
+		p.replicaErr.WithLabelValues(p.cfg.IndexPattern).Add(float64(failed))
+		log.Errorf("%d of %d users rejected by replica cluster", failed, len(batch))
+	}
+}
+
+// elasticHealthy checks if the cluster responds, failures of healthy cluster are caused by the documents.
+func (p *Indexer) elasticHealthy(ctx context.Context) bool {
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	_, err := p.esClient.ClusterHealth().Do(reqCtx)
+	return err == nil
+}
+
+// Classes of users rejected by Elasticsearch.
+const (
+	// failureRetryable is caused by the load of the cluster, user is sent again with the next bulk.
+	failureRetryable = "retryable"
+	// failurePermanent is caused by the user itself, e.g. mapping conflict, user is dead-lettered.
+	failurePermanent = "permanent"
+)
+
+// classifyFailure returns class of the failed bulk item.
+func classifyFailure(item *elastic.BulkResponseItem) string {
+	switch item.Status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return failureRetryable
+	}
+
+	if item.Error != nil && item.Error.Type == "es_rejected_execution_exception" {
+		return failureRetryable
+	}
+
+	return failurePermanent
+}
+
+// bulkDone records result of the executed bulk request. Users rejected with retryable errors are returned so they are
+// sent again, unless they were already retried MaxRetries times. Users rejected with permanent errors are
+// dead-lettered. Deletes of users which are not found succeed.
+func (p *Indexer) bulkDone(res *elastic.BulkResponse, batch []bulkItem) []bulkItem {
+	actions := len(batch)
+	if p.cfg.Verbose && log.IsLevelEnabled(log.DebugLevel) {
+		logIndexed(res, batch)
+	}
+
+	// bulk request succeeds even if some of its items were rejected, items are in the same order as the batch
+	var rejected, stale int
+	var retry []bulkItem
+	for i, items := range res.Items {
+		if i >= len(batch) {
+			break
+		}
+
+		retried := false
+		for action, item := range items {
+			if item.Status >= 200 && item.Status <= 299 {
+				if len(batch[i].doc) > 0 {
+					p.unchanged.indexed(batch[i].index+"/"+batch[i].id, batch[i].hash)
+				}
+				continue
+			}
+
+			// users which were never indexed or are already deleted are not found by the tombstones, e.g. when topic is
+			// replayed
+			if action == "delete" && item.Status == http.StatusNotFound {
+				log.Debugf("deleted user with id: %s not found", item.Id)
+				continue
+			}
+
+			// stale writes rejected by external versioning are expected when events arrive out of order
+			if item.Status == http.StatusConflict {
+				stale++
+				log.Warnf("stale user with id: %s rejected, newer version is already indexed", item.Id)
+				continue
+			}
+
+			reason := "unknown"
+			if item.Error != nil {
+				reason = fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
+			}
+
+			class := classifyFailure(item)
+			p.bulkItemErr.WithLabelValues(class).Inc()
+			if class == failureRetryable && batch[i].attempts < p.cfg.MaxRetries {
+				batch[i].attempts++
+				retry = append(retry, batch[i])
+				retried = true
+				if p.sampler.allow("retryable") {
+					log.Warnf("user with id: %s rejected, retrying with the next bulk. Status: %d, reason: %s", item.Id, item.Status, reason)
+				}
+				continue
+			}
+
+			rejected++
+			if p.sampler.allow("rejected") {
+				log.Errorf("can't index user with id: %s. Status: %d, reason: %s", item.Id, item.Status, reason)
+			}
+			if err := p.deadLetter.sendDocument(item.Id, batch[i].doc, fmt.Errorf("status: %d, reason: %s", item.Status, reason)); err != nil {
+				log.Error(err)
+			}
+		}
+
+		if !retried {
+			batch[i].ack.ack()
+		}
+	}
+	p.indexedErr.WithLabelValues(p.cfg.IndexPattern).Add(float64(rejected))
+	p.skipped.WithLabelValues("version_conflict").Add(float64(stale))
+	p.indexed.WithLabelValues(p.cfg.IndexPattern).Add(float64(actions - rejected - stale - len(retry)))
+	failed := atomic.AddInt64(&p.counters.failed, int64(rejected))
+	atomic.AddInt64(&p.counters.done, int64(actions-len(retry)))
+	atomic.StoreInt64(&p.counters.lastBulk, time.Now().UnixNano())
+
+	log.Infof("Bulk with %v users indexed (%v failed, %v stale, %v retried)! Total indexed users: %v, total failed users: %v",
+		actions, rejected, stale, len(retry), atomic.LoadInt64(&p.counters.enqued), failed)
+
+	return retry
+}
+
+// logIndexed logs every successfully indexed user. Items of the response are in the same order as the batch.
+func logIndexed(res *elastic.BulkResponse, batch []bulkItem) {
+	for i, items := range res.Items {
+		if i >= len(batch) {
+			return
+		}
+
+		for action, item := range items {
+			if item.Status < 200 || item.Status > 299 {
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"id":     item.Id,
+				"index":  item.Index,
+				"pnum":   batch[i].pnum,
+				"action": action,
+			}).Debug("user indexed")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	elastic "github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBulkDone(t *testing.T) {
+	tests := []struct {
+		name         string
+		action       string
+		status       int
+		result       string
+		errType      string
+		retried      bool
+		failed       bool
+		deadLettered bool
+	}{
+		{name: "indexed", action: "index", status: 201, result: "created"},
+		{name: "deleted", action: "delete", status: 200, result: "deleted"},
+		{name: "deleted user not found", action: "delete", status: 404, result: "not_found"},
+		{name: "stale delete", action: "delete", status: 409, errType: "version_conflict_engine_exception"},
+		{name: "index not found", action: "index", status: 404, errType: "index_not_found_exception", failed: true, deadLettered: true},
+		{name: "rejected", action: "index", status: 400, errType: "mapper_parsing_exception", failed: true, deadLettered: true},
+		{name: "too many requests", action: "index", status: 429, errType: "es_rejected_execution_exception", retried: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &recordingProducer{}
+			p := newTestBulkIndexer(&deadLetter{topic: "users-dlq", producer: producer})
+
+			acked := false
+			item := bulkItem{index: "users", id: "7", pnum: 7, ack: func() { acked = true }}
+			if tt.action == "index" {
+				item.doc = []byte(`{"id":7}`)
+			}
+
+			res := &elastic.BulkResponse{Items: []map[string]*elastic.BulkResponseItem{{
+				tt.action: {Index: "users", Id: "7", Status: tt.status, Result: tt.result},
+			}}}
+			if tt.errType != "" {
+				res.Items[0][tt.action].Error = &elastic.ErrorDetails{Type: tt.errType}
+			}
+
+			retry := p.bulkDone(res, []bulkItem{item})
+
+			if retried := len(retry) == 1; retried != tt.retried {
+				t.Errorf("retried = %v, want %v", retried, tt.retried)
+			}
+			if acked == tt.retried {
+				t.Errorf("acked = %v, want %v", acked, !tt.retried)
+			}
+			if failed := p.counters.failed == 1; failed != tt.failed {
+				t.Errorf("failed = %v, want %v", failed, tt.failed)
+			}
+			if deadLettered := len(producer.sent) == 1; deadLettered != tt.deadLettered {
+				t.Errorf("dead-lettered = %v, want %v", deadLettered, tt.deadLettered)
+			}
+		})
+	}
+}
+
+// newTestBulkIndexer returns indexer recording bulk results to unregistered metrics.
+func newTestBulkIndexer(dl *deadLetter) *Indexer {
+	counter := func(name, label string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, []string{label})
+	}
+
+	return &Indexer{
+		cfg:         &config.Config{IndexPattern: "users", MaxRetries: 3},
+		deadLetter:  dl,
+		sampler:     newLogSampler(1, 1),
+		indexed:     counter("indexed_total", "index"),
+		indexedErr:  counter("indexed_total_err", "index"),
+		bulkItemErr: counter("bulk_item_total_err", "class"),
+		skipped:     counter("skipped_total", "reason"),
+	}
+}
+
+func TestOversized(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDocBytes int
+		doc         string
+		oversized   bool
+	}{
+		{name: "no limit", maxDocBytes: 0, doc: `{"id":7,"caption":"long caption"}`},
+		{name: "under limit", maxDocBytes: 64, doc: `{"id":7,"caption":"long caption"}`},
+		{name: "at limit", maxDocBytes: 8, doc: `{"id":7}`},
+		{name: "over limit", maxDocBytes: 16, doc: `{"id":7,"caption":"long caption"}`, oversized: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &recordingProducer{}
+			p := newTestBulkIndexer(&deadLetter{topic: "users-dlq", producer: producer})
+			p.cfg.MaxDocBytes = tt.maxDocBytes
+
+			if oversized := p.oversized("7", []byte(tt.doc)); oversized != tt.oversized {
+				t.Fatalf("oversized = %v, want %v", oversized, tt.oversized)
+			}
+			if deadLettered := len(producer.sent) == 1; deadLettered != tt.oversized {
+				t.Errorf("dead-lettered = %v, want %v", deadLettered, tt.oversized)
+			}
+		})
+	}
+}
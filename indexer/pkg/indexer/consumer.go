@@ -0,0 +1,514 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newSaramaConfig creates config of the Kafka consumer group.
+func newSaramaConfig(cfg *config.Config) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_3_0_0
+	config.ClientID = clientID(cfg)
+	config.Consumer.Return.Errors = true
+	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	initial, err := initialOffset(cfg.InitialOffset)
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Offsets.Initial = initial
+	config.Consumer.Offsets.CommitInterval = cfg.CommitInterval.Duration
+
+	if cfg.KafkaUsername != "" || cfg.KafkaPassword != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = cfg.KafkaUsername
+		config.Net.SASL.Password = cfg.KafkaPassword
+	}
+
+	return config, nil
+}
+
+// newProducerConfig creates config of the Kafka producer with version, client id and credentials of the consumer.
+func newProducerConfig(consumer *sarama.Config) *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = consumer.Version
+	config.ClientID = consumer.ClientID
+	config.Net = consumer.Net
+	config.Producer.Retry.Max = 10
+	return config
+}
+
+// clientID returns ClientID of the config, followed by the hostname when ClientIDHostname is set. Characters of the
+// hostname not accepted by sarama are replaced.
+func clientID(cfg *config.Config) string {
+	if !cfg.ClientIDHostname {
+		return cfg.ClientID
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warnf("can't get hostname, using client id %s. Err: %v", cfg.ClientID, err)
+		return cfg.ClientID
+	}
+
+	return cfg.ClientID + "-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, hostname)
+}
+
+// initialOffset maps InitialOffset config value to the sarama offset used when consumer group has no committed offset.
+func initialOffset(name string) (int64, error) {
+	switch name {
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	case "newest":
+		return sarama.OffsetNewest, nil
+	default:
+		return 0, fmt.Errorf("invalid InitialOffset %q, must be one of: oldest, newest", name)
+	}
+}
+
+// maxRejoinBackoff upper limit of the delay between attempts to rejoin the consumer group.
+const maxRejoinBackoff = time.Minute
+
+// newConsumer returns handler of the consumed messages which sends users to out.
+func (p *Indexer) newConsumer(out chan userEvent, limit *limiter) *Consumer {
+	return &Consumer{
+		cfg:               p.cfg,
+		out:               out,
+		joined:            &p.consumerReady,
+		consecutiveErrors: &p.consecutiveErrors,
+		counters:          &p.counters,
+		unmarshal:         p.unmarshal,
+		transform:         p.transform,
+		filter:            p.filter,
+		redact:            p.redact,
+		sampler:           p.sampler,
+		skipped:           p.skipped,
+		deadLetter:        p.deadLetter,
+		failedMessages:    p.failedMessages,
+		timeouts:          p.timeouts,
+		seeker:            p.seeker,
+		pauser:            &p.pauser,
+		limit:             limit,
+		received:          p.received,
+		receivedErr:       p.receivedErr,
+	}
+}
+
+// streamUsers consumes users from Kafka until ctx is cancelled. Sink is flushed whenever partitions are revoked.
+// Returned channel is closed once consumer is stopped.
+func (p *Indexer) streamUsers(ctx context.Context, limit *limiter, s sink) chan userEvent {
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+	topics := p.cfg.Topics
+	if p.cfg.RetryTopic != "" {
+		topics = append(append([]string{}, topics...), p.cfg.RetryTopic)
+	}
+
+	/**
+	 * Setup a new Sarama consumer group
+	 */
+	consumer := p.newConsumer(out, limit)
+	consumer.flush = s.Flush
+	log.Infof("Joining consumer group %s", p.group)
+
+	// cancels the current session when consumer group should be rejoined
+	var (
+		sessionMu     sync.Mutex
+		cancelSession context.CancelFunc
+	)
+	go p.watchConsumerErrors(func() {
+		sessionMu.Lock()
+		defer sessionMu.Unlock()
+		if cancelSession != nil {
+			cancelSession()
+		}
+	})
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		backoff := p.cfg.BaseBackoff.Duration
+		for {
+			sessionCtx, cancel := context.WithCancel(ctx)
+			sessionMu.Lock()
+			cancelSession = cancel
+			sessionMu.Unlock()
+
+			err := p.kafkaConsumer.Consume(sessionCtx, topics, consumer)
+			rejoin := sessionCtx.Err() != nil
+			cancel()
+
+			// check if context was cancelled, signaling that the consumer should stop
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil && !rejoin {
+				// regular rebalance
+				backoff = p.cfg.BaseBackoff.Duration
+				continue
+			}
+
+			if err != nil {
+				p.consumerErr.WithLabelValues(p.group).Inc()
+				log.Errorf("Error from consumer: %v", err)
+			}
+
+			delay := jitter(backoff)
+			log.Warnf("Rejoining consumer group %s in %v", p.group, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			if backoff *= 2; backoff > maxRejoinBackoff {
+				backoff = maxRejoinBackoff
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		log.Println("terminating: context cancelled")
+
+		// no ConsumeClaim is running after Consume returns so it's safe to close the channel
+		wg.Wait()
+		if err := p.kafkaConsumer.Close(); err != nil {
+			log.Errorf("Error closing consumer: %v", err)
+		}
+		if err := p.kafkaClient.Close(); err != nil {
+			log.Errorf("Error closing client: %v", err)
+		}
+		close(out)
+	}()
+
+	return out
+}
+
+// watchConsumerErrors logs errors of the consumer group. When MaxConsecutiveErrors errors happen without any message
+// consumed in between, rejoin is called. It returns when consumer group is closed.
+func (p *Indexer) watchConsumerErrors(rejoin func()) {
+	for err := range p.kafkaConsumer.Errors() {
+		p.consumerErr.WithLabelValues(p.group).Inc()
+		log.Errorf("Error from consumer group: %v", err)
+
+		if atomic.AddInt32(&p.consecutiveErrors, 1) >= int32(p.cfg.MaxConsecutiveErrors) {
+			log.Warnf("%d consecutive errors from consumer group, rejoining", p.cfg.MaxConsecutiveErrors)
+			atomic.StoreInt32(&p.consecutiveErrors, 0)
+			rejoin()
+		}
+	}
+}
+
+// Consumer represents a Sarama consumer group consumer
+type Consumer struct {
+	cfg       *config.Config
+	counters  *counters
+	unmarshal unmarshalFunc
+	transform transformer
+	filter    userFilter
+	redact    redaction
+	sampler   *logSampler
+	skipped   *prometheus.CounterVec
+	out       chan userEvent
+	joined    *int32
+	// reset whenever message is consumed
+	consecutiveErrors *int32
+	deadLetter        *deadLetter
+	failedMessages    *prometheus.CounterVec
+	timeouts          *prometheus.CounterVec
+	seeker            *timestampSeeker
+	pauser            *pauser
+	limit             *limiter
+	received          *prometheus.CounterVec
+	receivedErr       *prometheus.CounterVec
+
+	// offsets of the current session, used with "after-index" CommitStrategy
+	offsetsMu sync.Mutex
+	offsets   *offsetTracker
+	// flush writes pending users of the sink, nil when partitions are assigned statically
+	flush func() error
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim
+func (consumer *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	if err := consumer.seeker.seek(session); err != nil {
+		return err
+	}
+
+	// Mark the consumer as ready
+	atomic.StoreInt32(consumer.joined, 1)
+	log.Println("Sarama consumer up and running!...")
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited. Offsets marked before it
+// returns are committed before partitions are given up, so pending users are flushed first. Users not flushed within
+// RebalanceFlushTimeout are consumed again by the next owner of the partition.
+func (consumer *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	if consumer.flush == nil {
+		return nil
+	}
+
+	timeout := consumer.cfg.RebalanceFlushTimeout.Duration
+	log.Infof("Consumer group session ended, flushing pending users")
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		consumer.drain(session, time.Now().Add(timeout))
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		log.Warnf("pending users not flushed within %v, their offsets are not committed", timeout)
+	}
+
+	return nil
+}
+
+// rebalanceDrainInterval how often sink is flushed until all users of the ended session are written.
+const rebalanceDrainInterval = 500 * time.Millisecond
+
+// drain flushes the sink until no message of the session is pending or deadline passes. Users buffered in the channel
+// reach the sink after the first flush, so it's flushed again.
+func (consumer *Consumer) drain(session sarama.ConsumerGroupSession, deadline time.Time) {
+	for {
+		if err := consumer.flush(); err != nil {
+			log.Errorf("can't flush sink. Err: %v", err)
+		}
+
+		if consumer.pendingMessages(session) == 0 || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(rebalanceDrainInterval)
+	}
+}
+
+// pendingMessages returns number of messages of the session not marked yet. It's always zero unless "after-index"
+// CommitStrategy is used.
+func (consumer *Consumer) pendingMessages(session sarama.ConsumerGroupSession) int {
+	consumer.offsetsMu.Lock()
+	offsets := consumer.offsets
+	consumer.offsetsMu.Unlock()
+
+	if offsets == nil || offsets.session != session {
+		return 0
+	}
+	return offsets.pendingMessages()
+}
+
+// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
+func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		// session of the paused consumer keeps sending heartbeats, it ends only on rebalance or shutdown
+		if !consumer.pauser.wait(session.Context().Done()) {
+			return nil
+		}
+
+		if !consumer.waitRetryDelay(session, msg) {
+			return nil
+		}
+
+		if !consumer.consume(session, msg) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// waitRetryDelay delays message from the retry topic until RetryDelay passes since it was published. Messages of the
+// partition are ordered by their timestamps, so waiting for the first one doesn't delay the next ones. It returns false
+// when session ends while waiting.
+func (consumer *Consumer) waitRetryDelay(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) bool {
+	if msg.Topic != consumer.cfg.RetryTopic {
+		return true
+	}
+
+	wait := time.Until(msg.Timestamp.Add(consumer.cfg.RetryDelay.Duration))
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-session.Context().Done():
+		return false
+	}
+}
+
+// consume passes decoded message to the indexer. It returns false when no more messages should be consumed.
+func (consumer *Consumer) consume(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) bool {
+	atomic.StoreInt32(consumer.consecutiveErrors, 0)
+	// payload is not logged, it may contain PII removed by RedactFields
+	log.Debugf("received message from %s, partition: %d, offset: %d", msg.Topic, msg.Partition, msg.Offset)
+
+	// span continues the trace of the producer when message carries trace context
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headersCarrier(msg.Headers))
+	_, span := tracer().Start(ctx, "consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination", msg.Topic),
+			attribute.Int64("messaging.kafka.partition", int64(msg.Partition)),
+			attribute.Int64("messaging.kafka.offset", msg.Offset),
+		),
+	)
+	defer span.End()
+
+	ack := consumer.track(session, msg)
+
+	// documents were already transformed, decoding them again would fail or transform them twice
+	if consumer.cfg.ReprocessDLQ && isDocument(msg) {
+		consumer.skipped.WithLabelValues("dead_letter_document").Inc()
+		atomic.AddInt64(&consumer.counters.filtered, 1)
+		if consumer.sampler.allow("document") {
+			log.Warnf("document %s dead-lettered by the indexer can't be reprocessed, skipping it. Partition: %d, offset: %d",
+				string(msg.Key), msg.Partition, msg.Offset)
+		}
+		ack()
+		return true
+	}
+
+	event, ok, err := decodeEventTimeout(consumer.cfg, consumer.unmarshal, consumer.transform, msg.Value, consumer.cfg.MessageTimeout.Duration)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "can't decode message")
+		consumer.receivedErr.WithLabelValues(msg.Topic).Inc()
+		atomic.AddInt64(&consumer.counters.errors, 1)
+		if err == errProcessingTimeout {
+			consumer.timeouts.WithLabelValues(msg.Topic).Inc()
+			log.Warnf("processing of message exceeded %v, skipping it. Partition: %d, offset: %d",
+				consumer.cfg.MessageTimeout.Duration, msg.Partition, msg.Offset)
+		} else if consumer.sampler.allow("decode") {
+			log.Errorf("can't decode data from queue. Partition: %d, offset: %d, err: %v", msg.Partition, msg.Offset, err)
+		}
+		disposition, dlErr := consumer.deadLetter.send(msg, err)
+		if dlErr != nil {
+			log.Error(dlErr)
+			disposition = dispositionDropped
+		}
+		consumer.failedMessages.WithLabelValues(disposition).Inc()
+		if disposition != dispositionRetried {
+			log.Warnf("message from %s, partition: %d, offset: %d is %s after %d retries", msg.Topic, msg.Partition,
+				msg.Offset, disposition, retryCount(msg))
+		}
+		// commit past the malformed message so it doesn't block the partition
+		ack()
+		return true
+	}
+
+	if !ok {
+		ack()
+		return true
+	}
+
+	if consumer.filter(event.User) {
+		span.SetAttributes(attribute.Bool("filtered", true))
+		consumer.skipped.WithLabelValues("filtered").Inc()
+		atomic.AddInt64(&consumer.counters.filtered, 1)
+		ack()
+		return true
+	}
+
+	if !event.Deleted {
+		consumer.redact(&event.User)
+	}
+	event.partition = msg.Partition
+	event.spanContext = span.SpanContext()
+
+	// message over the limit isn't marked, it's consumed again by the next run
+	if !consumer.limit.take() {
+		return false
+	}
+
+	if consumer.cfg.CommitStrategy == config.CommitAfterIndex {
+		event.ack = ack
+		consumer.out <- event
+	} else {
+		consumer.out <- event
+		ack()
+	}
+	consumer.limit.done()
+
+	received := atomic.AddInt64(&consumer.counters.received, 1)
+	consumer.received.WithLabelValues(msg.Topic).Inc()
+
+	if received%1000 == 0 {
+		log.Infof("received %d messages from Kafka", received)
+	}
+
+	return true
+}
+
+// track returns function marking the message. With "after-index" CommitStrategy the message is marked once it and all
+// previous messages of its partition are finished.
+func (consumer *Consumer) track(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) ackFunc {
+	if consumer.cfg.CommitStrategy != config.CommitAfterIndex {
+		return func() { session.MarkMessage(msg, "") }
+	}
+
+	consumer.offsetsMu.Lock()
+	// messages of the previous session are consumed again after rebalance, its offsets are no longer tracked
+	if consumer.offsets == nil || consumer.offsets.session != session {
+		consumer.offsets = newOffsetTracker(session)
+	}
+	offsets := consumer.offsets
+	consumer.offsetsMu.Unlock()
+
+	return offsets.track(msg)
+}
+
+// checkTopics returns error when any of the consumed topics doesn't exist. Consumer group would wait for such topic
+// without any error otherwise.
+func checkTopics(client sarama.Client, cfg *config.Config) error {
+	available, err := client.Topics()
+	if err != nil {
+		return fmt.Errorf("can't list kafka topics. err: %v", err)
+	}
+	sort.Strings(available)
+
+	topics := cfg.Topics
+	if cfg.RetryTopic != "" {
+		topics = append(append([]string{}, topics...), cfg.RetryTopic)
+	}
+	if cfg.ReprocessDLQ {
+		topics = []string{cfg.DeadLetterTopic}
+	}
+
+	for _, topic := range topics {
+		i := sort.SearchStrings(available, topic)
+		if i == len(available) || available[i] != topic {
+			return fmt.Errorf("topic %s not found; available topics: %s", topic, strings.Join(available, ", "))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestConsumer returns consumer of the config passing users to the returned channel. Config must be validated,
+// metrics are not registered.
+func newTestConsumer(t *testing.T, cfg *config.Config) (*Consumer, chan userEvent) {
+	t.Helper()
+
+	if cfg.DobPolicy == "" {
+		cfg.DobPolicy = DobPolicyNullify
+	}
+	transform, err := newTransformChain(cfg.Transformers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redact, err := newRedaction(cfg.RedactFields, cfg.RedactMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(chan userEvent, 16)
+	return &Consumer{
+		cfg:               cfg,
+		counters:          &counters{},
+		unmarshal:         jsonUnmarshal(cfg),
+		transform:         transform,
+		filter:            newUserFilter(cfg),
+		redact:            redact,
+		sampler:           newLogSampler(1, 1),
+		skipped:           prometheus.NewCounterVec(prometheus.CounterOpts{Name: "skipped_total"}, []string{"reason"}),
+		out:               out,
+		consecutiveErrors: new(int32),
+		failedMessages:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "failed_total"}, []string{"disposition"}),
+		timeouts:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "timeouts_total"}, []string{"topic"}),
+		limit:             &limiter{},
+		received:          prometheus.NewCounterVec(prometheus.CounterOpts{Name: "received_total"}, []string{"topic"}),
+		receivedErr:       prometheus.NewCounterVec(prometheus.CounterOpts{Name: "received_err_total"}, []string{"topic"}),
+	}, out
+}
+
+// testSession is the session of a single partition dropping marked offsets.
+func testSession(topic string) sarama.ConsumerGroupSession {
+	return &partitionSession{claims: map[string][]int32{topic: {0}}}
+}
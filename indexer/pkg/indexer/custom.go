@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Event is a user update passed from the custom Source or to the custom Sink.
+type Event struct {
+	models.User
+	// Deleted marks tombstones, user is removed from the index instead of being indexed
+	Deleted bool
+	// Version of the user used for external versioning, ignored when it's zero
+	Version int64
+}
+
+// Source provides users of the "custom" source. Returned channel must be closed once the source is stopped, at the
+// latest when ctx is cancelled. Users go through DobPolicy, Transformers, skip filters and redaction as users consumed
+// from Kafka.
+type Source func(ctx context.Context) (<-chan Event, error)
+
+// Sink receives users of the "custom" sink. Index is called by a single goroutine, Flush may be called concurrently
+// with it.
+type Sink interface {
+	// Index writes the user, writes may be buffered until Flush or Close is called.
+	Index(event Event) error
+	// Flush writes buffered users.
+	Flush() error
+	// Close flushes buffered users and releases resources. No users are passed to the sink after Close is called.
+	Close() error
+}
+
+// SetSource sets source of users read when Source is "custom". It must be called before Index.
+func (p *Indexer) SetSource(source Source) {
+	p.source = source
+}
+
+// SetSink sets sink users are written to when Sink is "custom". It must be called before Index.
+func (p *Indexer) SetSink(sink Sink) {
+	p.customSink = sink
+}
+
+// streamCustom reads users from the custom source. Returned channel is closed once source is stopped or ctx is
+// cancelled.
+func (p *Indexer) streamCustom(ctx context.Context, limit *limiter) (chan userEvent, error) {
+	if p.source == nil {
+		return nil, fmt.Errorf("custom source is not set")
+	}
+
+	events, err := p.source(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't start custom source. err: %v", err)
+	}
+
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+	atomic.StoreInt32(&p.consumerReady, 1)
+
+	go func() {
+		defer close(out)
+		for {
+			var e Event
+			var ok bool
+			select {
+			case e, ok = <-events:
+			case <-ctx.Done():
+				log.Println("terminating: context cancelled")
+				return
+			}
+			if !ok {
+				log.Info("Custom source stopped")
+				return
+			}
+
+			event := userEvent{User: e.User, Deleted: e.Deleted, Version: e.Version}
+			if ok, err := prepareEvent(p.cfg, p.transform, &event); err != nil || !ok {
+				if err != nil {
+					atomic.AddInt64(&p.counters.errors, 1)
+					if p.sampler.allow("decode") {
+						log.Errorf("can't transform user %d. err: %v", event.Pnum, err)
+					}
+				}
+				continue
+			}
+
+			if !p.sendEvent(ctx, out, limit, event, "custom") {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// customSink passes users to the Sink set by SetSink.
+type customSink struct {
+	p    *Indexer
+	sink Sink
+}
+
+// Index passes user to the custom sink, user is acknowledged once the sink returns.
+func (s *customSink) Index(event userEvent) error {
+	err := s.sink.Index(Event{User: event.User, Deleted: event.Deleted, Version: event.Version})
+	atomic.AddInt64(&s.p.counters.enqued, 1)
+	atomic.AddInt64(&s.p.counters.done, 1)
+	if err != nil {
+		atomic.AddInt64(&s.p.counters.failed, 1)
+	}
+	event.ack.ack()
+
+	return err
+}
+
+// Flush flushes the custom sink.
+func (s *customSink) Flush() error {
+	return s.sink.Flush()
+}
+
+// Close closes the custom sink.
+func (s *customSink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,154 @@
+package indexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Dispositions of messages which can't be processed.
+const (
+	dispositionRetried      = "retried"
+	dispositionDeadLettered = "dead_lettered"
+	dispositionDropped      = "dropped"
+)
+
+// retryCountHeader holds number of times message went through the retry topic.
+const retryCountHeader = "retry-count"
+
+// kindHeader tells what kind of record was dead-lettered, it's set only for documents which failed to be indexed.
+const kindHeader = "dlq-kind"
+
+// kindDocument marks rendered documents, unlike consumed messages they can't be passed through the pipeline again.
+const kindDocument = "document"
+
+// deadLetter forwards messages which can't be processed to the retry topic and, once retries are exhausted, to the
+// dead letter topic.
+type deadLetter struct {
+	topic string
+	// retryTopic is empty when messages are dead-lettered immediately
+	retryTopic  string
+	maxAttempts int
+	producer    sarama.SyncProducer
+}
+
+func newDeadLetter(brokers []string, topic, retryTopic string, maxAttempts int, config *sarama.Config) (*deadLetter, error) {
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("can't create dead letter producer: %w", err)
+	}
+
+	return &deadLetter{topic: topic, retryTopic: retryTopic, maxAttempts: maxAttempts, producer: producer}, nil
+}
+
+// send publishes msg to the retry topic or, when it was retried MaxRetryAttempts times already, to the dead letter
+// topic. Origin of the message, number of retries and the reason of failure are sent as headers. Returned disposition
+// tells where the message ended up.
+func (d *deadLetter) send(msg *sarama.ConsumerMessage, reason error) (string, error) {
+	if d == nil {
+		return dispositionDropped, nil
+	}
+
+	attempts := retryCount(msg)
+	topic, disposition := d.topic, dispositionDeadLettered
+	if d.retryTopic != "" && attempts < d.maxAttempts {
+		topic, disposition = d.retryTopic, dispositionRetried
+		attempts++
+	}
+	if topic == "" {
+		return dispositionDropped, nil
+	}
+
+	// origin is kept from the first failure when message comes from the retry topic or is reprocessed
+	headers := []sarama.RecordHeader{
+		{Key: []byte("origin-topic"), Value: []byte(msg.Topic)},
+		{Key: []byte("origin-partition"), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		{Key: []byte("origin-offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	}
+	if msg.Topic == d.retryTopic || msg.Topic == d.topic {
+		headers = headers[:0]
+		for _, h := range msg.Headers {
+			if h != nil && strings.HasPrefix(string(h.Key), "origin-") {
+				headers = append(headers, *h)
+			}
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(retryCountHeader), Value: []byte(strconv.Itoa(attempts))},
+		sarama.RecordHeader{Key: []byte("error"), Value: []byte(reason.Error())},
+	)
+
+	message := &sarama.ProducerMessage{
+		Topic:     topic,
+		Key:       sarama.ByteEncoder(msg.Key),
+		Value:     sarama.ByteEncoder(msg.Value),
+		Headers:   headers,
+		Timestamp: time.Now(),
+	}
+
+	if _, _, err := d.producer.SendMessage(message); err != nil {
+		return "", fmt.Errorf("can't send message to topic %s: %w", topic, err)
+	}
+
+	return disposition, nil
+}
+
+// retryCount returns number of times msg went through the retry topic, zero for messages which weren't retried.
+func retryCount(msg *sarama.ConsumerMessage) int {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == retryCountHeader {
+			n, _ := strconv.Atoi(string(h.Value))
+			return n
+		}
+	}
+
+	return 0
+}
+
+// sendDocument publishes document which can't be indexed to the dead letter topic. Document id is used as the key.
+// Documents are not retried, they are not encoded in the format of messages, and are marked by the kindHeader so they
+// are skipped by -reprocess-dlq.
+func (d *deadLetter) sendDocument(id string, doc []byte, reason error) error {
+	if d == nil || d.topic == "" {
+		return nil
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic: d.topic,
+		Key:   sarama.StringEncoder(id),
+		Value: sarama.ByteEncoder(doc),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte(kindHeader), Value: []byte(kindDocument)},
+			{Key: []byte("error"), Value: []byte(reason.Error())},
+		},
+		Timestamp: time.Now(),
+	}
+
+	if _, _, err := d.producer.SendMessage(message); err != nil {
+		return fmt.Errorf("can't send document %s to dead letter topic %s: %w", id, d.topic, err)
+	}
+
+	return nil
+}
+
+// isDocument returns true when msg is a document published by sendDocument.
+func isDocument(msg *sarama.ConsumerMessage) bool {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == kindHeader {
+			return string(h.Value) == kindDocument
+		}
+	}
+
+	return false
+}
+
+func (d *deadLetter) close() error {
+	if d == nil {
+		return nil
+	}
+
+	return d.producer.Close()
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingProducer keeps sent messages instead of producing them.
+type recordingProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+// consumed returns the produced message as it's consumed from the dead letter topic.
+func consumed(t *testing.T, msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	t.Helper()
+
+	key, err := msg.Key.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumed := &sarama.ConsumerMessage{Topic: msg.Topic, Offset: offset, Key: key, Value: value}
+	for i := range msg.Headers {
+		consumed.Headers = append(consumed.Headers, &msg.Headers[i])
+	}
+	return consumed
+}
+
+func TestReprocessDeadLetters(t *testing.T) {
+	producer := &recordingProducer{}
+	dl := &deadLetter{topic: "users-dlq", producer: producer}
+
+	// message which failed to be decoded and document which failed to be indexed
+	failed := &sarama.ConsumerMessage{Topic: "users", Partition: 2, Offset: 10, Key: []byte("7"), Value: []byte(`{"id":7,"email":"a@example.com"}`)}
+	if _, err := dl.send(failed, errors.New("can't decode")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.sendDocument("8", []byte(`{"id":8,"email":"b@example.com","age":30}`), errors.New("rejected")); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.sent) != 2 {
+		t.Fatalf("%d records dead-lettered, want 2", len(producer.sent))
+	}
+
+	consumer, out := newTestConsumer(t, &config.Config{ReprocessDLQ: true, StrictDecode: true})
+	skipped := consumer.skipped
+	session := testSession("users-dlq")
+
+	tests := []struct {
+		name     string
+		msg      *sarama.ConsumerMessage
+		document bool
+		pnum     int64
+	}{
+		{name: "message", msg: consumed(t, producer.sent[0], 0), pnum: 7},
+		{name: "document", msg: consumed(t, producer.sent[1], 1), document: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if isDocument(tt.msg) != tt.document {
+				t.Fatalf("isDocument = %v, want %v", !tt.document, tt.document)
+			}
+
+			before := testutil.ToFloat64(skipped.WithLabelValues("dead_letter_document"))
+			if !consumer.consume(session, tt.msg) {
+				t.Fatal("consumer stopped")
+			}
+			skippedDocuments := testutil.ToFloat64(skipped.WithLabelValues("dead_letter_document")) - before
+
+			if tt.document {
+				if skippedDocuments != 1 {
+					t.Errorf("%v documents skipped, want 1", skippedDocuments)
+				}
+				if len(out) != 0 {
+					t.Errorf("document passed through the pipeline: %+v", <-out)
+				}
+				return
+			}
+
+			if skippedDocuments != 0 {
+				t.Errorf("%v documents skipped, want 0", skippedDocuments)
+			}
+			if consumer.counters.errors != 0 {
+				t.Fatalf("%d messages failed to decode", consumer.counters.errors)
+			}
+			select {
+			case event := <-out:
+				if event.Pnum != tt.pnum {
+					t.Errorf("user %d reprocessed, want %d", event.Pnum, tt.pnum)
+				}
+			default:
+				t.Error("message not passed through the pipeline")
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+// Package indexer consumes users from Kafka (or file, or custom source) and indexes them in Elasticsearch. Use package
+// pipeline to embed it in other programs together with the HTTP endpoints, signals and shutdown timeout:
+//
+//	cfg := &config.Config{Brokers: brokers, Topics: topics, Elastics: elastics, HTTPPort: 8080}
+//	p, err := pipeline.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//
+//	// Run returns when ctx is cancelled and all consumed users are flushed
+//	return p.Run(ctx)
+package indexer
@@ -0,0 +1,160 @@
+package indexer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// newElasticClient creates client connected to the Elasticsearch cluster with given urls. Credentials and TLS settings
+// are shared by primary and replica clusters.
+func newElasticClient(cfg *config.Config, urls []string) (*elastic.Client, error) {
+	tlsConfig, err := newElasticTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        cfg.ElasticMaxIdleConns,
+		MaxIdleConnsPerHost: cfg.ElasticMaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.ElasticIdleConnTimeout.Duration,
+	}
+	httpClient := &http.Client{Transport: tr}
+
+	options := []elastic.ClientOptionFunc{
+		elastic.SetURL(urls...),
+		elastic.SetHttpClient(httpClient),
+		elastic.SetSniff(cfg.ElasticSniff),
+		elastic.SetHealthcheck(*cfg.ElasticHealthcheck),
+		elastic.SetGzip(cfg.ElasticGzip),
+		elastic.SetScheme("https"),
+	}
+
+	if cfg.ElasticUser != "" || cfg.ElasticPassword != "" {
+		options = append(options, elastic.SetBasicAuth(cfg.ElasticUser, cfg.ElasticPassword))
+	}
+
+	// connect to the cluster
+	client, err := elastic.NewClient(options...)
+	if err != nil {
+		return nil, fmt.Errorf("can't create elastic client. err: %v", err)
+	}
+
+	return client, nil
+}
+
+// connectElastic creates client and waits until the cluster is available, retrying with exponential backoff.
+func connectElastic(cfg *config.Config, urls []string) (*elastic.Client, error) {
+	var client *elastic.Client
+	err := retry("elastic connection", cfg.ElasticConnectRetries, cfg.ElasticConnectBackoff.Duration, func() error {
+		var err error
+		if client, err = newElasticClient(cfg, urls); err != nil {
+			return err
+		}
+
+		ctx, cancel := elasticContext(context.Background(), cfg.ElasticTimeout.Duration)
+		defer cancel()
+		if _, err := client.ClusterHealth().Do(ctx); err != nil {
+			return fmt.Errorf("can't check elastic cluster health. err: %v", err)
+		}
+		return nil
+	})
+
+	return client, err
+}
+
+// newElasticTLSConfig builds TLS config for Elasticsearch connections. Server certificate is verified only when
+// ElasticCACert is set. When ElasticClientCert and ElasticClientKey are set, mutual TLS is used.
+func newElasticTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	if cfg.ElasticCACert != "" {
+		caCert, err := ioutil.ReadFile(cfg.ElasticCACert)
+		if err != nil {
+			return nil, fmt.Errorf("can't read elastic CA cert. err: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("can't parse elastic CA cert %s", cfg.ElasticCACert)
+		}
+
+		tlsConfig.RootCAs = pool
+		tlsConfig.InsecureSkipVerify = false
+	}
+
+	if cfg.ElasticClientCert != "" || cfg.ElasticClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ElasticClientCert, cfg.ElasticClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't load elastic client cert. err: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// elasticContext returns context of a single request to Elasticsearch. Requests started before ctx is cancelled are
+// aborted on shutdown, later ones (flushing remaining users) are limited only by the timeout.
+func elasticContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// warmUp waits until the cluster is at least yellow and creates the index of the current users before consumption
+// starts. Indices depending on the user, e.g. on IndexField or Dob, are created when their first user arrives.
+func (p *Indexer) warmUp(ctx context.Context) error {
+	if p.esClient == nil {
+		return nil
+	}
+
+	err := retry("elasticsearch health", p.cfg.MaxRetries, p.cfg.BaseBackoff.Duration, func() error {
+		reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+		defer cancel()
+
+		health, err := p.esClient.ClusterHealth().WaitForYellowStatus().Do(reqCtx)
+		if err != nil {
+			return err
+		}
+		if health.TimedOut {
+			return fmt.Errorf("cluster %s is %s", health.ClusterName, health.Status)
+		}
+
+		log.Infof("Warm-up: cluster %s is %s", health.ClusterName, health.Status)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("elasticsearch is not ready. err: %v", err)
+	}
+
+	if p.cfg.WriteAlias != "" {
+		if err := p.ensureAlias(ctx); err != nil {
+			return err
+		}
+	}
+
+	if p.cfg.IndexField == "" && p.cfg.IndexDateField != "dob" {
+		index := p.indexName(models.User{})
+		if err := p.ensureIndex(ctx, index); err != nil {
+			return fmt.Errorf("index %s is not ready. err: %v", index, err)
+		}
+		log.Infof("Warm-up: index %s is ready", index)
+	}
+
+	log.Info("Warm-up: sink is ready, starting consumption")
+	return nil
+}
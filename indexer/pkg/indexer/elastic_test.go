@@ -0,0 +1,59 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/olivere/elastic/v7"
+)
+
+func TestElasticGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		gzip     bool
+		encoding string
+	}{
+		{name: "gzip disabled", gzip: false, encoding: ""},
+		{name: "gzip enabled", gzip: true, encoding: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var encoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/_bulk" {
+					mu.Lock()
+					encoding = r.Header.Get("Content-Encoding")
+					mu.Unlock()
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"took":1,"errors":false,"items":[]}`)
+			}))
+			defer server.Close()
+
+			healthcheck := false
+			cfg := &config.Config{ElasticGzip: tt.gzip, ElasticHealthcheck: &healthcheck}
+			client, err := newElasticClient(cfg, []string{server.URL})
+			if err != nil {
+				t.Fatalf("can't create client: %v", err)
+			}
+
+			bulk := client.Bulk().Add(elastic.NewBulkIndexRequest().Index("users").Id("1").Doc(map[string]string{"email": "a"}))
+			if _, err := bulk.Do(context.Background()); err != nil {
+				t.Fatalf("can't send bulk: %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if encoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, tt.encoding)
+			}
+		})
+	}
+}
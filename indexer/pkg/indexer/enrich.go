@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxEnrichResponseBytes limits the response of the enrichment service read into memory.
+const maxEnrichResponseBytes = 1 << 20
+
+// enricher POSTs users to the enrichment service and merges its response into their documents. Number of concurrent
+// requests is limited by the slots. Every index worker enriches its users synchronously, so there are never more
+// concurrent requests than workers.
+type enricher struct {
+	url    string
+	client *http.Client
+	slots  chan struct{}
+}
+
+func newEnricher(url string, client *http.Client, concurrency int) *enricher {
+	return &enricher{url: url, client: client, slots: make(chan struct{}, concurrency)}
+}
+
+// enrich returns the document with fields of the service response. Response fields override fields of the document.
+// Users drained on shutdown, when ctx is already cancelled, are still enriched within the client timeout.
+func (e *enricher) enrich(ctx context.Context, user models.User, doc []byte) ([]byte, error) {
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	body, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal user. err: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("can't create enrichment request. err: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't call enrichment service. err: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("enrichment service responded with status %d", res.StatusCode)
+	}
+
+	var extra map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxEnrichResponseBytes)).Decode(&extra); err != nil {
+		return nil, fmt.Errorf("enrichment response is not a JSON object. err: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range extra {
+		fields[name] = value
+	}
+
+	return json.Marshal(fields)
+}
+
+// enrich returns the document enriched by the EnrichURL service. Document is returned unchanged when enrichment is
+// disabled or the service fails, so users are indexed even when the service is unavailable.
+func (p *Indexer) enrich(ctx context.Context, id string, user models.User, doc []byte) []byte {
+	if p.enricher == nil {
+		return doc
+	}
+
+	enriched, err := p.enricher.enrich(ctx, user, doc)
+	if err != nil {
+		p.enrichErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+		if p.sampler.allow("enrich") {
+			log.Warnf("can't enrich user with id: %s, indexing it unenriched. Err: %v", id, err)
+		}
+		return doc
+	}
+
+	return enriched
+}
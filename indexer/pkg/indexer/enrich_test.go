@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+func TestEnrich(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user models.User
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if user.Pnum == 0 {
+			http.Error(w, "unknown user", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"segment":"user-%d","country":48}`, user.Pnum)
+	}))
+	defer server.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		pnum int64
+		want map[string]interface{}
+		err  bool
+	}{
+		{
+			name: "merged",
+			ctx:  context.Background(),
+			pnum: 7,
+			want: map[string]interface{}{"id": 7.0, "country": 48.0, "segment": "user-7"},
+		},
+		{
+			name: "ctx cancelled on shutdown",
+			ctx:  cancelled,
+			pnum: 8,
+			want: map[string]interface{}{"id": 8.0, "country": 48.0, "segment": "user-8"},
+		},
+		{name: "service failed", ctx: context.Background(), pnum: 0, err: true},
+	}
+
+	e := newEnricher(server.URL, server.Client(), 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := models.User{Pnum: tt.pnum, Country: 1}
+			doc, err := json.Marshal(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enriched, err := e.enrich(tt.ctx, user, doc)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error: %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(enriched, &got); err != nil {
+				t.Fatalf("can't unmarshal enriched document %s: %v", enriched, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("enriched document = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
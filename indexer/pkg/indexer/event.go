@@ -0,0 +1,57 @@
+package indexer
+
+import (
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// userEvent is a user update consumed from Kafka. It carries the user together with the event metadata which is not
+// a part of the indexed document.
+type userEvent struct {
+	models.User
+	// Deleted marks tombstone events, user is removed from the index instead of being indexed
+	Deleted bool `json:"deleted,omitempty"`
+	// Version is used for external versioning, Elasticsearch rejects events older than the indexed document
+	Version int64 `json:"version,omitempty"`
+
+	// partition of the Kafka message, used for sharding workers
+	partition int32
+	// spanContext of the consume span, bulk span is linked to the spans of all its users
+	spanContext trace.SpanContext
+	// ack marks offset of the message with "after-index" CommitStrategy
+	ack ackFunc
+}
+
+// document is the user indexed in Elasticsearch together with the fields derived at index time.
+type document struct {
+	models.User
+	// Age in years computed from Dob, not set when user has no valid date of birth
+	Age *int `json:"age,omitempty"`
+}
+
+func newDocument(user models.User, now time.Time) document {
+	doc := document{User: user}
+	if user.Dob == nil {
+		return doc
+	}
+
+	if dob, err := time.Parse(dobLayout, *user.Dob); err == nil {
+		age := ageAt(dob, now)
+		doc.Age = &age
+	}
+
+	return doc
+}
+
+// ageAt returns number of full years between dob and now. Users born on 29th of February have birthday on 1st of March
+// in non-leap years.
+func ageAt(dob, now time.Time) int {
+	age := now.Year() - dob.Year()
+	if now.Month() < dob.Month() || now.Month() == dob.Month() && now.Day() < dob.Day() {
+		age--
+	}
+
+	return age
+}
@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+func TestAgeAt(t *testing.T) {
+	tests := []struct {
+		name string
+		dob  string
+		now  string
+		age  int
+	}{
+		{name: "birthday today", dob: "1990-05-10", now: "2020-05-10", age: 30},
+		{name: "birthday passed this year", dob: "1990-05-10", now: "2020-08-01", age: 30},
+		{name: "birthday not yet this month", dob: "1990-05-10", now: "2020-04-30", age: 29},
+		{name: "birthday not yet this day", dob: "1990-05-10", now: "2020-05-09", age: 29},
+		{name: "born this year", dob: "2020-01-01", now: "2020-12-31", age: 0},
+		{name: "leap day in leap year", dob: "2000-02-29", now: "2004-02-29", age: 4},
+		{name: "leap day before birthday in non-leap year", dob: "2000-02-29", now: "2001-02-28", age: 0},
+		{name: "leap day after birthday in non-leap year", dob: "2000-02-29", now: "2001-03-01", age: 1},
+		{name: "leap year before leap day", dob: "2000-03-01", now: "2004-02-29", age: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dob, err := time.Parse(dobLayout, tt.dob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now, err := time.Parse(dobLayout, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if age := ageAt(dob, now); age != tt.age {
+				t.Errorf("ageAt(%s, %s) = %d, want %d", tt.dob, tt.now, age, tt.age)
+			}
+		})
+	}
+}
+
+func TestNewDocumentAge(t *testing.T) {
+	dob := func(s string) *string { return &s }
+	now := time.Date(2020, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		dob  *string
+		age  *int
+	}{
+		{name: "no dob", dob: nil},
+		{name: "invalid dob", dob: dob("10.05.1990")},
+		{name: "valid dob", dob: dob("1990-05-11"), age: func(i int) *int { return &i }(29)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument(models.User{Dob: tt.dob}, now)
+			switch {
+			case tt.age == nil && doc.Age != nil:
+				t.Errorf("Age = %d, want unset", *doc.Age)
+			case tt.age != nil && doc.Age == nil:
+				t.Errorf("Age unset, want %d", *tt.age)
+			case tt.age != nil && *doc.Age != *tt.age:
+				t.Errorf("Age = %d, want %d", *doc.Age, *tt.age)
+			}
+		})
+	}
+}
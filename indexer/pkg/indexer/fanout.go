@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// extraSinkBuffer number of users waiting for the extra sink before the primary sink is blocked as well.
+const extraSinkBuffer = 64
+
+// extraSink is a sink users are copied to. Users are written by its own goroutine so it doesn't wait for other sinks.
+type extraSink struct {
+	name   string
+	policy string
+	sink   sink
+	events chan userEvent
+}
+
+// fanoutSink passes users to the primary sink and copies them to the extra sinks concurrently. Only the primary sink
+// acknowledges users, failed copies are handled by the FailurePolicy of their sink.
+type fanoutSink struct {
+	primary sink
+	extras  []*extraSink
+	wg      sync.WaitGroup
+}
+
+func (p *Indexer) newFanoutSink(primary sink) (*fanoutSink, error) {
+	s := &fanoutSink{primary: primary}
+	for _, cfg := range p.cfg.ExtraSinks {
+		extra, err := p.newExtraSink(cfg)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.extras = append(s.extras, extra)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			p.copyUsers(extra)
+		}()
+	}
+
+	return s, nil
+}
+
+func (p *Indexer) newExtraSink(cfg config.SinkConfig) (*extraSink, error) {
+	extra := &extraSink{policy: cfg.FailurePolicy, events: make(chan userEvent, extraSinkBuffer)}
+
+	// copies are not counted as users passing the pipeline
+	switch cfg.Type {
+	case config.SinkStdout:
+		extra.name = cfg.Type
+		extra.sink = newJSONSink(os.Stdout, &counters{})
+	case config.SinkFile:
+		extra.name = cfg.Type + ":" + cfg.Path
+		f, err := os.Create(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("can't create sink file %s. err: %v", cfg.Path, err)
+		}
+		extra.sink = newJSONSink(f, &counters{})
+	case config.SinkKafka:
+		extra.name = cfg.Type + ":" + cfg.Topic
+		s, err := p.newKafkaSink(cfg.Topic, func(err error) { p.sinkFailed(extra, err) })
+		if err != nil {
+			return nil, err
+		}
+		extra.sink = s
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+
+	return extra, nil
+}
+
+// copyUsers writes users to the extra sink until its channel is closed.
+func (p *Indexer) copyUsers(extra *extraSink) {
+	for event := range extra.events {
+		if err := extra.sink.Index(event); err != nil {
+			p.sinkFailed(extra, err)
+		}
+	}
+}
+
+// sinkFailed counts failed write to the extra sink. Indexer exits when the sink has "fatal" FailurePolicy.
+func (p *Indexer) sinkFailed(extra *extraSink, err error) {
+	p.sinkErr.WithLabelValues(extra.name).Inc()
+	if extra.policy == config.SinkFailureFatal {
+		log.Fatalf("Can't write user to %s sink. Err: %v", extra.name, err)
+	}
+
+	if p.sampler.allow("sink") {
+		log.Errorf("can't write user to %s sink. Err: %v", extra.name, err)
+	}
+}
+
+// Index copies user to the extra sinks and passes it to the primary sink.
+func (s *fanoutSink) Index(event userEvent) error {
+	copied := event
+	copied.ack = nil
+	for _, extra := range s.extras {
+		extra.events <- copied
+	}
+
+	return s.primary.Index(event)
+}
+
+// Flush flushes all sinks, users still waiting for the extra sinks are not flushed.
+func (s *fanoutSink) Flush() error {
+	for _, extra := range s.extras {
+		if err := extra.sink.Flush(); err != nil {
+			log.Errorf("can't flush %s sink. Err: %v", extra.name, err)
+		}
+	}
+
+	return s.primary.Flush()
+}
+
+// Close waits until extra sinks write all users and closes all sinks.
+func (s *fanoutSink) Close() error {
+	for _, extra := range s.extras {
+		close(extra.events)
+	}
+	s.wg.Wait()
+
+	for _, extra := range s.extras {
+		if err := extra.sink.Close(); err != nil {
+			log.Errorf("can't close %s sink. Err: %v", extra.name, err)
+		}
+	}
+
+	return s.primary.Close()
+}
@@ -0,0 +1,50 @@
+package indexer
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// userField returns value of the models.User field with given name, e.g. "Pnum" or "Email".
+// Second value is false when the field is nil or has zero value.
+func userField(user models.User, name string) (string, bool) {
+	v := reflect.ValueOf(user).FieldByName(name)
+	if !v.IsValid() {
+		return "", false
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+
+	if v.IsZero() {
+		return "", false
+	}
+
+	return fmt.Sprint(v.Interface()), true
+}
+
+// checkUserField checks that models.User has a field with given name which can be used as a document key.
+func checkUserField(name string) error {
+	field, ok := reflect.TypeOf(models.User{}).FieldByName(name)
+	if !ok {
+		return fmt.Errorf("user has no field %q", name)
+	}
+
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int32, reflect.Int64, reflect.Float64:
+		return nil
+	default:
+		return fmt.Errorf("user field %q of type %s can't be used as a key", name, field.Type)
+	}
+}
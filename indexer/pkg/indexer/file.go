@@ -0,0 +1,105 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxLineSize maximum size of a single user in the file.
+const maxLineSize = 10 * 1024 * 1024
+
+// streamFile reads users from the newline-delimited JSON file. Returned channel is closed once whole file is read or
+// ctx is cancelled.
+func (p *Indexer) streamFile(ctx context.Context, limit *limiter) (chan userEvent, error) {
+	f, err := os.Open(p.cfg.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("can't open users file. err: %v", err)
+	}
+
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+
+	atomic.StoreInt32(&p.consumerReady, 1)
+	log.Infof("Reading users from file %s", p.cfg.FilePath)
+
+	go func() {
+		defer close(out)
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+		var line int
+		for scanner.Scan() {
+			line++
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+
+			event, ok, err := decodeEvent(p.cfg, jsonUnmarshal(p.cfg), p.transform, data)
+			if err != nil {
+				p.receivedErr.WithLabelValues(p.cfg.FilePath).Inc()
+				atomic.AddInt64(&p.counters.errors, 1)
+				if p.sampler.allow("decode") {
+					log.Errorf("can't decode user from line %d. err: %v", line, err)
+				}
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+
+			if !p.sendEvent(ctx, out, limit, event, p.cfg.FilePath) {
+				if ctx.Err() != nil {
+					log.Infof("terminating: context cancelled, stopped at line %d", line)
+				}
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			log.Errorf("can't read users file. err: %v", err)
+			return
+		}
+
+		log.Infof("Whole file %s read, %d lines", p.cfg.FilePath, line)
+	}()
+
+	return out, nil
+}
+
+// sendEvent passes decoded user of the source to out, users matched by the filter are skipped. It returns false when
+// source should stop, because Limit was reached or ctx was cancelled.
+func (p *Indexer) sendEvent(ctx context.Context, out chan userEvent, limit *limiter, event userEvent, source string) bool {
+	if p.filter(event.User) {
+		p.skipped.WithLabelValues("filtered").Inc()
+		atomic.AddInt64(&p.counters.filtered, 1)
+		return true
+	}
+
+	if !event.Deleted {
+		p.redact(&event.User)
+	}
+
+	if !limit.take() {
+		return false
+	}
+
+	select {
+	case out <- event:
+		p.received.WithLabelValues(source).Inc()
+		atomic.AddInt64(&p.counters.received, 1)
+		limit.done()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
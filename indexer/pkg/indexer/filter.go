@@ -0,0 +1,28 @@
+package indexer
+
+import (
+	"regexp"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// userFilter returns true when user must not be indexed, e.g. synthetic test users.
+type userFilter func(user models.User) bool
+
+// newUserFilter creates filter skipping users with email matching SkipEmailPattern or with Pnum within SkipPnumRange.
+// Config must be validated.
+func newUserFilter(cfg *config.Config) userFilter {
+	var email *regexp.Regexp
+	if cfg.SkipEmailPattern != "" {
+		email = regexp.MustCompile(cfg.SkipEmailPattern)
+	}
+
+	return func(user models.User) bool {
+		if email != nil && user.Email != nil && email.MatchString(*user.Email) {
+			return true
+		}
+
+		return len(cfg.SkipPnumRange) == 2 && user.Pnum >= cfg.SkipPnumRange[0] && user.Pnum <= cfg.SkipPnumRange[1]
+	}
+}
@@ -0,0 +1,157 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// templateName is the name under which MappingTemplate is installed.
+const templateName = "users"
+
+// indexFieldNone replaces empty IndexField in the index name.
+const indexFieldNone = "none"
+
+// dobLayout is the layout of the date of birth sent by the feeders.
+const dobLayout = "2006-01-02"
+
+// indexName returns name of the index for user based on the IndexPattern. Supported placeholders are:
+// %Y - year, %m - month, %d - day, %f - value of the IndexField. Date is taken from user's Dob when IndexDateField is
+// set to "dob", otherwise (or when user has no valid Dob) current time is used. All users are written to the
+// WriteAlias when it's set.
+func (p *Indexer) indexName(user models.User) string {
+	if p.cfg.WriteAlias != "" {
+		return p.cfg.WriteAlias
+	}
+
+	t := time.Now().UTC()
+	if p.cfg.IndexDateField == "dob" && user.Dob != nil {
+		if dob, err := time.Parse(dobLayout, *user.Dob); err == nil {
+			t = dob
+		}
+	}
+
+	var field string
+	if p.cfg.IndexField != "" {
+		field = indexFieldValue(user, p.cfg.IndexField)
+	}
+
+	return formatIndexName(p.cfg.IndexPattern, t, field)
+}
+
+func formatIndexName(pattern string, t time.Time, field string) string {
+	return strings.NewReplacer(
+		"%Y", t.Format("2006"),
+		"%m", t.Format("01"),
+		"%d", t.Format("02"),
+		"%f", field,
+	).Replace(pattern)
+}
+
+// indexWildcard returns pattern matching all indices of the IndexPattern.
+func indexWildcard(pattern string) string {
+	return strings.NewReplacer("%Y", "*", "%m", "*", "%d", "*", "%f", "*").Replace(pattern)
+}
+
+// indexFieldValue returns value of the field which can be used in the index name. Index names must be lowercase and
+// can't contain some characters, users without the field go to the "none" index.
+func indexFieldValue(user models.User, name string) string {
+	value, ok := userField(user, name)
+	if !ok {
+		return indexFieldNone
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', '*', '?', '"', '<', '>', '|', ' ', ',', '#', ':':
+			return '_'
+		}
+		return r
+	}, strings.ToLower(value))
+}
+
+// ensureIndex creates index with users mapping if it doesn't exist yet. Indices which were already checked are cached.
+// Failure on the replica cluster is only logged.
+func (p *Indexer) ensureIndex(ctx context.Context, name string) error {
+	p.indicesMu.Lock()
+	defer p.indicesMu.Unlock()
+
+	if p.indices[name] {
+		return nil
+	}
+
+	if err := p.createIndex(ctx, p.esClient, name); err != nil {
+		return err
+	}
+
+	if p.replicaClient != nil {
+		if err := p.createIndex(ctx, p.replicaClient, name); err != nil {
+			p.replicaErr.WithLabelValues(p.cfg.IndexPattern).Inc()
+			log.Errorf("can't prepare index on replica cluster. Err: %v", err)
+		}
+	}
+
+	p.indices[name] = true
+
+	return nil
+}
+
+// createIndex creates index with users mapping, mapping of already existing index is checked for drift.
+func (p *Indexer) createIndex(ctx context.Context, client *elastic.Client, name string) error {
+	existsCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+	exists, err := client.IndexExists(name).Do(existsCtx)
+	if err != nil {
+		return fmt.Errorf("can't check if index %s exists. err: %v", name, err)
+	}
+
+	if !exists {
+		mapping, err := p.usersMapping()
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Creating index '%s'", name)
+		createCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+		defer cancel()
+		_, err = client.
+			CreateIndex(name).
+			BodyString(mapping).
+			Do(createCtx)
+		if err != nil {
+			return fmt.Errorf("can't create index %s. err: %v", name, err)
+		}
+
+		return nil
+	}
+
+	return p.checkMapping(ctx, client, name)
+}
+
+// putTemplate installs index template read from MappingTemplate so indices created dynamically get users mapping.
+func (p *Indexer) putTemplate(client *elastic.Client) error {
+	body, err := ioutil.ReadFile(p.cfg.MappingTemplate)
+	if err != nil {
+		return fmt.Errorf("can't read mapping template %s. err: %v", p.cfg.MappingTemplate, err)
+	}
+
+	ctx, cancel := elasticContext(context.Background(), p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+	_, err = client.
+		IndexPutTemplate(templateName).
+		BodyString(string(body)).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("can't put index template %s. err: %v", templateName, err)
+	}
+
+	log.Infof("Index template '%s' installed from %s", templateName, p.cfg.MappingTemplate)
+
+	return nil
+}
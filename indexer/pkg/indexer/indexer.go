@@ -2,70 +2,221 @@ package indexer
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
-	"github.com/mateuszdyminski/am-pipeline/models"
 	elastic "github.com/olivere/elastic/v7"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Indexer allows to Index data taken from Kafka in ElasticSearch
 type Indexer struct {
-	cfg           *config.Config
-	kafkaConsumer sarama.ConsumerGroup
-	esClient      *elastic.Client
-	indexed       *prometheus.CounterVec
-	indexedErr    *prometheus.CounterVec
-	received      *prometheus.CounterVec
-	receivedErr   *prometheus.CounterVec
+	cfg            *config.Config
+	group          string
+	kafkaClient    sarama.Client
+	kafkaConsumer  sarama.ConsumerGroup
+	deadLetter     *deadLetter
+	seeker         *timestampSeeker
+	esClient       *elastic.Client
+	replicaClient  *elastic.Client
+	unmarshal      unmarshalFunc
+	transform      transformer
+	filter         userFilter
+	redact         redaction
+	sampler        *logSampler
+	tracerProvider *sdktrace.TracerProvider
+	// nil when EnrichURL is not set
+	enricher *enricher
+	// set by the embedding program for the "custom" source and sink
+	source     Source
+	customSink Sink
+	// nil when UnchangedCacheSize is not set
+	unchanged *unchangedCache
+	// semaphore of bulk requests, nil when their number is not limited
+	inFlight   chan struct{}
+	indexed    *prometheus.CounterVec
+	indexedErr *prometheus.CounterVec
+	bulkErr    *prometheus.CounterVec
+	// bulk requests by status: success or failure
+	bulkDuration *prometheus.HistogramVec
+	// rejected users by class: retryable or permanent
+	bulkItemErr *prometheus.CounterVec
+	skipped     *prometheus.CounterVec
+	lag         *prometheus.GaugeVec
+	consumerErr *prometheus.CounterVec
+	// messages which can't be decoded by their disposition: retried, dead-lettered or dropped
+	failedMessages *prometheus.CounterVec
+	// messages which processing exceeded MessageTimeout
+	timeouts   *prometheus.CounterVec
+	replicaErr *prometheus.CounterVec
+	enrichErr  *prometheus.CounterVec
+	sinkErr    *prometheus.CounterVec
+	// lookups of the unchanged documents cache by result: hit or miss
+	unchangedCache *prometheus.CounterVec
+	received       *prometheus.CounterVec
+	receivedErr    *prometheus.CounterVec
+
+	indicesMu sync.Mutex
+	indices   map[string]bool
+
+	// readiness state, accessed atomically
+	consumerReady int32
+	elasticReady  int32
+	bulkFailures  int32
+
+	consecutiveErrors int32
+
+	pauser pauser
+
+	started  time.Time
+	counters counters
 }
 
+// unreadyAfterBulkFailures number of consecutive bulk failures after which indexer reports it's not ready.
+const unreadyAfterBulkFailures = 3
+
 // NewIndexer creates new Indexer.
 func NewIndexer(cfg *config.Config) (*Indexer, error) {
-	// kafka consumer group initialization
-	config := sarama.NewConfig()
-	config.Version = sarama.V2_3_0_0
-	config.Consumer.Return.Errors = true
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	if cfg.ReadFromOldest {
-		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	if err := checkUserField(cfg.IDField); err != nil {
+		return nil, fmt.Errorf("invalid IDField. err: %v", err)
+	}
+
+	if cfg.IndexField != "" {
+		if err := checkUserField(cfg.IndexField); err != nil {
+			return nil, fmt.Errorf("invalid IndexField. err: %v", err)
+		}
 	}
 
-	// init consumer
-	brokers := cfg.Brokers
-	group := "consumer-group"
+	if cfg.RoutingField != "" {
+		if err := checkUserField(cfg.RoutingField); err != nil {
+			return nil, fmt.Errorf("invalid RoutingField. err: %v", err)
+		}
+	}
 
-	kafkaConsumer, err := sarama.NewConsumerGroup(brokers, group, config)
+	transform, err := newTransformChain(cfg.Transformers)
 	if err != nil {
-		return nil, fmt.Errorf("error while init consumer group. err: %s", err)
+		return nil, fmt.Errorf("invalid Transformers. err: %v", err)
 	}
 
-	// elasticsearch client initialization
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	redact, err := newRedaction(cfg.RedactFields, cfg.RedactMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RedactFields. err: %v", err)
 	}
-	httpClient := &http.Client{Transport: tr}
-
-	// connect to the cluster
-	client, err := elastic.NewClient(
-		elastic.SetURL(cfg.Elastics...),
-		elastic.SetBasicAuth(cfg.ElasticUser, cfg.ElasticPassword),
-		elastic.SetHttpClient(httpClient),
-		elastic.SetSniff(false),
-		elastic.SetScheme("https"),
-	)
+
+	tracerProvider, err := newTracerProvider(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("can't create elastic client. err: %v", err)
+		return nil, err
+	}
+
+	// format of Kafka messages, file source always reads JSON
+	unmarshal := jsonUnmarshal(cfg)
+	switch cfg.MessageFormat {
+	case config.MessageFormatAvro:
+		unmarshal = newSchemaRegistry(cfg.SchemaRegistryURL).unmarshal
+	case config.MessageFormatProtobuf:
+		unmarshal = unmarshalProto
+	}
+
+	// elasticsearch client initialization, it is connected before kafka so users are not consumed when the cluster is
+	// down. It is not needed in dry-run mode or when users are written to other sink
+	var client, replicaClient *elastic.Client
+	if cfg.UsesElastic() {
+		if err := validateMapping(cfg); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if client, err = connectElastic(cfg, cfg.Elastics); err != nil {
+			return nil, err
+		}
+
+		// replica cluster is optional, indexer works without it when it's not available
+		if len(cfg.ReplicaElastics) > 0 {
+			if replicaClient, err = connectElastic(cfg, cfg.ReplicaElastics); err != nil {
+				log.Errorf("can't connect to replica elastic cluster, users are indexed in primary cluster only. Err: %v", err)
+			}
+		}
+	}
+
+	var (
+		kafkaClient   sarama.Client
+		kafkaConsumer sarama.ConsumerGroup
+		dl            *deadLetter
+		seeker        *timestampSeeker
+	)
+
+	// kafka consumer group initialization, it is not needed when users are read from file, custom source or in self-test
+	group := cfg.ConsumerGroup
+	if cfg.ReprocessDLQ {
+		group += reprocessGroupSuffix
+	}
+	if cfg.Source != config.SourceFile && cfg.Source != config.SourceCustom && !cfg.SelfTest {
+		saramaConfig, err := newSaramaConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		kafkaClient, err = sarama.NewClient(cfg.Brokers, saramaConfig)
+		if err != nil {
+			if saramaConfig.Net.SASL.Enable {
+				return nil, fmt.Errorf("error while init kafka client, check SASL credentials of user %q. err: %s", cfg.KafkaUsername, err)
+			}
+			return nil, fmt.Errorf("error while init kafka client. err: %s", err)
+		}
+
+		if *cfg.CheckTopicExists {
+			if err := checkTopics(kafkaClient, cfg); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(cfg.Partitions) > 0 {
+			if err := checkPartitions(kafkaClient, cfg.Topics, cfg.Partitions); err != nil {
+				return nil, err
+			}
+		}
+
+		if cfg.Replay {
+			if err := resetOffsets(kafkaClient, group, cfg.Topics); err != nil {
+				return nil, err
+			}
+		}
+
+		// tail doesn't touch the consumer group, its messages are neither committed nor dead-lettered. Dead letter topic
+		// is reprocessed from statically assigned partitions.
+		if !cfg.Tail && !cfg.ReprocessDLQ {
+			kafkaConsumer, err = sarama.NewConsumerGroupFromClient(group, kafkaClient)
+			if err != nil {
+				return nil, fmt.Errorf("error while init consumer group. err: %s", err)
+			}
+		}
+
+		if cfg.StartTimestamp != "" {
+			// already validated
+			timestamp, _ := time.Parse(time.RFC3339, cfg.StartTimestamp)
+			seeker = newTimestampSeeker(kafkaClient, timestamp)
+		}
+
+		if (cfg.DeadLetterTopic != "" || cfg.RetryTopic != "") && !cfg.Tail && (!cfg.ReprocessDLQ || cfg.ReprocessRequeue) {
+			producerConfig := newProducerConfig(saramaConfig)
+			producerConfig.Producer.Return.Successes = true
+
+			// requeued messages go directly to the dead letter topic
+			retryTopic := cfg.RetryTopic
+			if cfg.ReprocessDLQ {
+				retryTopic = ""
+			}
+			if dl, err = newDeadLetter(cfg.Brokers, cfg.DeadLetterTopic, retryTopic, cfg.MaxRetryAttempts, producerConfig); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	received := prometheus.NewCounterVec(
@@ -108,186 +259,285 @@ func NewIndexer(cfg *config.Config) (*Indexer, error) {
 		[]string{"index"},
 	)
 
-	prometheus.Register(received)
-	prometheus.Register(receivedErr)
-	prometheus.Register(indexed)
-	prometheus.Register(indexedErr)
+	bulkErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_total_err",
+			Help:      "The total number of failed bulk requests.",
+		},
+		[]string{"index"},
+	)
 
-	indexer := &Indexer{
-		cfg:           cfg,
-		kafkaConsumer: kafkaConsumer,
-		esClient:      client,
-		indexed:       indexed,
-		indexedErr:    indexedErr,
-		received:      received,
-		receivedErr:   receivedErr,
-	}
+	// time of waiting for a free slot of MaxInFlightBulks is not included
+	bulkDuration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_duration_seconds",
+			Help:      "Seconds spent executing bulk requests.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"status"},
+	)
 
-	return indexer, nil
-}
+	bulkItemErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "bulk_item_total_err",
+			Help:      "The total number of users rejected by Elasticsearch by the class of error.",
+		},
+		[]string{"class"},
+	)
 
-// Index starts reading data from Kafka and indexing it in ELastic.
-func (p *Indexer) Index() error {
-	p.indexUsers(p.streamUsers())
+	skipped := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "skipped_total",
+			Help:      "The total number of users skipped during indexing.",
+		},
+		[]string{"reason"},
+	)
 
-	return nil
-}
+	lag := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "consumer_lag",
+			Help:      "The number of messages in the partition which are not consumed yet.",
+		},
+		[]string{"topic", "partition"},
+	)
 
-// BulkSize size of the bulk.
-const BulkSize = 1
+	replicaErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "replica_total_err",
+			Help:      "The total number of failed requests to the replica Elasticsearch cluster.",
+		},
+		[]string{"index"},
+	)
 
-func (p *Indexer) indexUsers(users chan models.User) {
-	exists, err := p.esClient.IndexExists("users").Do(context.Background())
-	if err != nil {
-		log.Fatalf("Can't check if index exists. Err: %v", err)
-	}
+	enrichErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "enrich_total_err",
+			Help:      "The total number of users indexed unenriched because the enrichment service failed.",
+		},
+		[]string{"index"},
+	)
 
-	if !exists {
-		log.Info("Creating index 'users'")
-		// Create an index if not exists
-		_, err = p.esClient.
-			CreateIndex("users").
-			BodyString(models.ElasticMappingString).
-			Do(context.Background())
-		if err != nil {
-			log.Fatalf("Can't create index. Err: %v", err)
-		}
-	}
+	sinkErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "sink_total_err",
+			Help:      "The total number of users which couldn't be copied to the extra sinks.",
+		},
+		[]string{"sink"},
+	)
 
-	var enqued int
-	bulkRequest := p.esClient.Bulk()
-	for user := range users {
-		if enqued > 0 && enqued%BulkSize == 0 {
-			if _, err := bulkRequest.Do(context.Background()); err != nil {
-				p.indexedErr.WithLabelValues("users").Inc()
-				log.Error("can't execute bulk. Err: %v", err)
-				continue
-			}
+	unchangedCache := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "unchanged_cache_total",
+			Help:      "The total number of lookups of the unchanged documents cache.",
+		},
+		[]string{"result"},
+	)
+
+	failedMessages := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "failed_messages_total",
+			Help:      "The total number of messages which can't be processed by their disposition.",
+		},
+		[]string{"disposition"},
+	)
 
-			p.indexed.WithLabelValues("users").Add(BulkSize)
-			log.Infof("Bulk with %v users indexed! Total indexed users: %v", BulkSize, enqued)
+	timeouts := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "message_timeouts_total",
+			Help:      "The total number of messages skipped because their processing exceeded MessageTimeout.",
+		},
+		[]string{"topic"},
+	)
 
-			bulkRequest = p.esClient.Bulk()
-		}
+	consumerErr := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "am",
+			Subsystem: "indexer",
+			Name:      "consumer_total_err",
+			Help:      "The total number of errors of the Kafka consumer group.",
+		},
+		[]string{"group"},
+	)
 
-		bulkRequest.Add(
-			elastic.NewBulkIndexRequest().
-				Index("users").
-				Type("_doc").
-				Id(fmt.Sprintf("%d", user.Pnum)).
-				Doc(user))
+	prometheus.Register(received)
+	prometheus.Register(receivedErr)
+	prometheus.Register(indexed)
+	prometheus.Register(indexedErr)
+	prometheus.Register(bulkErr)
+	prometheus.Register(bulkDuration)
+	prometheus.Register(bulkItemErr)
+	prometheus.Register(skipped)
+	prometheus.Register(lag)
+	prometheus.Register(consumerErr)
+	prometheus.Register(failedMessages)
+	prometheus.Register(timeouts)
+	prometheus.Register(replicaErr)
+	prometheus.Register(enrichErr)
+	prometheus.Register(sinkErr)
+	prometheus.Register(unchangedCache)
 
-		enqued++
+	indexer := &Indexer{
+		cfg:            cfg,
+		group:          group,
+		kafkaClient:    kafkaClient,
+		kafkaConsumer:  kafkaConsumer,
+		deadLetter:     dl,
+		seeker:         seeker,
+		esClient:       client,
+		replicaClient:  replicaClient,
+		unmarshal:      unmarshal,
+		transform:      transform,
+		filter:         newUserFilter(cfg),
+		redact:         redact,
+		sampler:        newLogSampler(cfg.LogSampleFirst, cfg.LogSampleEvery),
+		tracerProvider: tracerProvider,
+		indexed:        indexed,
+		indexedErr:     indexedErr,
+		bulkErr:        bulkErr,
+		bulkDuration:   bulkDuration,
+		bulkItemErr:    bulkItemErr,
+		skipped:        skipped,
+		lag:            lag,
+		consumerErr:    consumerErr,
+		failedMessages: failedMessages,
+		timeouts:       timeouts,
+		replicaErr:     replicaErr,
+		enrichErr:      enrichErr,
+		sinkErr:        sinkErr,
+		unchangedCache: unchangedCache,
+		unchanged:      newUnchangedCache(cfg.UnchangedCacheSize),
+		received:       received,
+		receivedErr:    receivedErr,
+		indices:        make(map[string]bool),
+		elasticReady:   1,
+		started:        time.Now(),
 	}
 
-	if bulkRequest.NumberOfActions() > 0 {
-		if _, err := bulkRequest.Do(context.Background()); err != nil {
-			log.Fatalf("Can't execute bulk. Err: %v", err)
-		}
+	if cfg.MaxInFlightBulks > 0 {
+		indexer.inFlight = make(chan struct{}, cfg.MaxInFlightBulks)
 	}
-}
 
-func (p *Indexer) streamUsers() chan models.User {
-	out := make(chan models.User, 1024)
-	topics := []string{p.cfg.Topic}
-	ctx, cancel := context.WithCancel(context.Background())
-
-	/**
-	 * Setup a new Sarama consumer group
-	 */
-	consumer := Consumer{
-		out:         out,
-		ready:       make(chan bool),
-		received:    p.received,
-		receivedErr: p.receivedErr,
+	if cfg.EnrichURL != "" {
+		indexer.enricher = newEnricher(cfg.EnrichURL, &http.Client{Timeout: cfg.EnrichTimeout.Duration}, cfg.EnrichConcurrency)
 	}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			if err := p.kafkaConsumer.Consume(ctx, topics, &consumer); err != nil {
-				log.Panicf("Error from consumer: %v", err)
-			}
-			// check if context was cancelled, signaling that the consumer should stop
-			if ctx.Err() != nil {
-				return
-			}
-			consumer.ready = make(chan bool)
+	if cfg.UsesElastic() && cfg.MappingTemplate != "" {
+		if err := indexer.putTemplate(client); err != nil {
+			return nil, err
 		}
-	}()
-
-	<-consumer.ready // Await till the consumer has been set up
-	log.Println("Sarama consumer up and running!...")
-
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
 
-	go func() {
-		select {
-		case <-ctx.Done():
-			log.Println("terminating: context cancelled")
-		case <-sigterm:
-			log.Println("terminating: via signal")
-		}
-		cancel()
-		wg.Wait()
-		if err := p.kafkaConsumer.Close(); err != nil {
-			log.Panicf("Error closing client: %v", err)
+		if replicaClient != nil {
+			if err := indexer.putTemplate(replicaClient); err != nil {
+				replicaErr.WithLabelValues(cfg.IndexPattern).Inc()
+				log.Errorf("can't prepare replica elastic cluster. Err: %v", err)
+			}
 		}
-	}()
+	}
 
-	return out
+	return indexer, nil
 }
 
-// Consumer represents a Sarama consumer group consumer
-type Consumer struct {
-	counter     int
-	out         chan models.User
-	ready       chan bool
-	received    *prometheus.CounterVec
-	receivedErr *prometheus.CounterVec
+// Ready returns true when indexer joined the Kafka consumer group and Elasticsearch accepts bulk requests.
+func (p *Indexer) Ready() bool {
+	return atomic.LoadInt32(&p.consumerReady) == 1 && atomic.LoadInt32(&p.elasticReady) == 1
 }
 
-// Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	// Mark the consumer as ready
-	close(consumer.ready)
-	return nil
-}
+// Index starts reading data from Kafka (or file, or custom source) and indexing it in ELastic. It returns when ctx is
+// cancelled (or whole file is read, or custom source is stopped) and all consumed users are flushed to the sink.
+func (p *Indexer) Index(ctx context.Context) error {
+	s, err := p.newSink(ctx)
+	if err != nil {
+		return err
+	}
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
-	return nil
-}
+	// source is stopped in the same way as on shutdown when Limit of messages is reached
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	limit := &limiter{limit: p.cfg.Limit, stop: stop}
 
-// ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
-func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		log.Infof("received message: %s", string(msg.Value))
-
-		var user models.User
-		if err := json.Unmarshal(msg.Value, &user); err != nil {
-			consumer.receivedErr.WithLabelValues(msg.Topic).Inc()
-			session.MarkMessage(msg, fmt.Sprintf("can't unmarshal data from queue. err: %s", err.Error()))
-			log.Error("can't unmarshal data from queue", err)
-			continue
-		}
+	go p.sampler.run(ctx)
+
+	// first bulks would fail and be retried when cluster or index is not ready yet
+	if err := p.warmUp(ctx); err != nil {
+		return err
+	}
+
+	if p.esClient != nil && p.cfg.RetentionDays > 0 {
+		go p.runRetention(ctx)
+	}
 
-		if *user.Dob == "0000-00-00" {
-			user.Dob = nil
+	var users chan userEvent
+	switch p.cfg.Source {
+	case config.SourceFile:
+		if users, err = p.streamFile(ctx, limit); err != nil {
+			return err
 		}
+	case config.SourceCustom:
+		if users, err = p.streamCustom(ctx, limit); err != nil {
+			return err
+		}
+	default:
+		if p.cfg.Tail {
+			if users, err = p.streamTail(ctx, limit); err != nil {
+				return err
+			}
+			break
+		}
+		if p.cfg.ReprocessDLQ {
+			if users, err = p.streamDeadLetters(ctx, limit); err != nil {
+				return err
+			}
+			break
+		}
+		if p.cfg.LagInterval.Duration > 0 {
+			go p.reportLag(ctx)
+		}
+		if len(p.cfg.Partitions) == 0 {
+			users = p.streamUsers(ctx, limit, s)
+		} else if users, err = p.streamPartitions(ctx, limit); err != nil {
+			return err
+		}
+	}
 
-		consumer.out <- user
+	// buffered users are drained and flushed before the dead letter producer is closed, workers may still send
+	// oversized and poison users to it
+	p.indexUsers(users, s)
 
-		session.MarkMessage(msg, "")
+	if p.cfg.ReprocessDLQ {
+		stats := p.Stats()
+		log.Infof("Reprocessed %d dead-lettered messages, %d failed to decode again, %d users rejected again",
+			stats.Received+stats.Errors+stats.Filtered, stats.Errors, stats.Failed)
+	}
 
-		consumer.counter++
-		consumer.received.WithLabelValues(msg.Topic).Inc()
+	if err := p.deadLetter.close(); err != nil {
+		log.Errorf("Error closing dead letter producer: %v", err)
+	}
 
-		if consumer.counter%1000 == 0 {
-			log.Infof("received %n messages from Kafka", consumer.counter)
+	if p.tracerProvider != nil {
+		if err := p.tracerProvider.Shutdown(context.Background()); err != nil {
+			log.Errorf("can't flush traces. Err: %v", err)
 		}
 	}
 
@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaSink produces documents to the Kafka topic keyed by the document id. Deleted users are produced as tombstones.
+// Messages are produced asynchronously, failed messages are reported to the failed function.
+type kafkaSink struct {
+	p        *Indexer
+	topic    string
+	producer sarama.AsyncProducer
+	errors   sync.WaitGroup
+}
+
+func (p *Indexer) newKafkaSink(topic string, failed func(error)) (*kafkaSink, error) {
+	saramaConfig, err := newSaramaConfig(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	producerConfig := newProducerConfig(saramaConfig)
+	producerConfig.Producer.Return.Errors = true
+
+	producer, err := sarama.NewAsyncProducer(p.cfg.Brokers, producerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can't create producer of topic %s: %w", topic, err)
+	}
+
+	s := &kafkaSink{p: p, topic: topic, producer: producer}
+	s.errors.Add(1)
+	go func() {
+		defer s.errors.Done()
+		for err := range producer.Errors() {
+			failed(err)
+		}
+	}()
+
+	return s, nil
+}
+
+// Index produces document of the user.
+func (s *kafkaSink) Index(event userEvent) error {
+	id, _ := s.p.documentID(event.User)
+	message := &sarama.ProducerMessage{Topic: s.topic, Key: sarama.StringEncoder(id)}
+	if !event.Deleted {
+		doc, err := s.p.marshalDocument(event.User, time.Now())
+		if err != nil {
+			return fmt.Errorf("can't marshal user with id: %s. err: %v", id, err)
+		}
+		message.Value = sarama.ByteEncoder(doc)
+	}
+
+	s.producer.Input() <- message
+	return nil
+}
+
+// Flush does nothing, producer sends buffered messages on its own.
+func (s *kafkaSink) Flush() error {
+	return nil
+}
+
+// Close waits until buffered messages are sent.
+func (s *kafkaSink) Close() error {
+	s.producer.AsyncClose()
+	s.errors.Wait()
+	return nil
+}
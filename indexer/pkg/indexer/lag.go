@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportLag periodically logs total consumer lag and updates lag gauges until ctx is cancelled.
+func (p *Indexer) reportLag(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.LagInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			total, err := p.updateLag()
+			if err != nil {
+				log.Warnf("can't compute consumer lag. Err: %v", err)
+				continue
+			}
+			log.Infof("Consumer lag: %d messages", total)
+		}
+	}
+}
+
+// updateLag computes lag of every partition of the subscribed topics as a difference between the high-water mark
+// and the offset committed by the consumer group. It returns the total lag.
+func (p *Indexer) updateLag() (int64, error) {
+	highWaterMarks := make(map[string]map[int32]int64)
+	request := &sarama.OffsetFetchRequest{ConsumerGroup: p.group, Version: 1}
+	for _, topic := range p.cfg.Topics {
+		partitions, err := p.kafkaClient.Partitions(topic)
+		if err != nil {
+			return 0, fmt.Errorf("can't get partitions of topic %s: %w", topic, err)
+		}
+
+		highWaterMarks[topic] = make(map[int32]int64)
+		for _, partition := range partitions {
+			hwm, err := p.kafkaClient.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return 0, fmt.Errorf("can't get high-water mark of %s/%d: %w", topic, partition, err)
+			}
+			highWaterMarks[topic][partition] = hwm
+			request.AddPartition(topic, partition)
+		}
+	}
+
+	coordinator, err := p.kafkaClient.Coordinator(p.group)
+	if err != nil {
+		return 0, fmt.Errorf("can't get coordinator of group %s: %w", p.group, err)
+	}
+
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return 0, fmt.Errorf("can't fetch offsets of group %s: %w", p.group, err)
+	}
+
+	var total int64
+	for topic, partitions := range highWaterMarks {
+		for partition, hwm := range partitions {
+			committed := int64(-1)
+			if block := response.GetBlock(topic, partition); block != nil && block.Err == sarama.ErrNoError {
+				committed = block.Offset
+			}
+
+			// nothing committed yet, consumer starts from the initial offset
+			if committed < 0 {
+				committed = hwm
+				if p.cfg.InitialOffset == "oldest" {
+					if committed, err = p.kafkaClient.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+						return 0, fmt.Errorf("can't get oldest offset of %s/%d: %w", topic, partition, err)
+					}
+				}
+			}
+
+			lag := hwm - committed
+			if lag < 0 {
+				lag = 0
+			}
+
+			p.lag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+			total += lag
+		}
+	}
+
+	return total, nil
+}
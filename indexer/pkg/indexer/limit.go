@@ -0,0 +1,33 @@
+package indexer
+
+import (
+	"context"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// limiter stops the source after the limit of messages is emitted. Zero limit means no limit.
+type limiter struct {
+	limit   int64
+	emitted int64
+	stop    context.CancelFunc
+}
+
+// take reserves place for a single message. It returns false when the limit is already reached and message must not be
+// emitted.
+func (l *limiter) take() bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	return atomic.AddInt64(&l.emitted, 1) <= l.limit
+}
+
+// done stops the source when the last message within the limit was emitted.
+func (l *limiter) done() {
+	if l.limit > 0 && atomic.LoadInt64(&l.emitted) >= l.limit {
+		log.Infof("Limit of %d messages reached, stopping", l.limit)
+		l.stop()
+	}
+}
@@ -0,0 +1,205 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// expectedProperties returns fields of the users mapping.
+func (p *Indexer) expectedProperties() (map[string]interface{}, error) {
+	body, err := p.usersMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	return mappingProperties(body)
+}
+
+func mappingProperties(body string) (map[string]interface{}, error) {
+	var mapping struct {
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(body), &mapping); err != nil {
+		return nil, fmt.Errorf("can't parse users mapping. err: %v", err)
+	}
+
+	return mapping.Mappings.Properties, nil
+}
+
+// validateMapping compares fields of the indexed document with the users mapping, so the struct and the mapping can't
+// silently diverge. Fields present in only one of them are logged. It fails when any of the MappingRequiredFields or
+// the RetentionField is not mapped.
+func validateMapping(cfg *config.Config) error {
+	body, err := usersMapping(cfg)
+	if err != nil {
+		return err
+	}
+
+	properties, err := mappingProperties(body)
+	if err != nil {
+		return err
+	}
+
+	fields := documentFields(reflect.TypeOf(document{}))
+	documented := make(map[string]bool, len(fields))
+	var unmapped []string
+	for _, field := range fields {
+		if to, ok := cfg.RenameFields[field]; ok {
+			field = to
+		}
+		documented[field] = true
+		if _, ok := properties[field]; !ok {
+			unmapped = append(unmapped, field)
+		}
+	}
+
+	if cfg.IndexedAtField != "" {
+		documented[cfg.IndexedAtField] = true
+	}
+
+	var unknown []string
+	for field := range properties {
+		if !documented[field] {
+			unknown = append(unknown, field)
+		}
+	}
+
+	if len(unmapped) > 0 {
+		sort.Strings(unmapped)
+		log.Warnf("Users mapping misses document fields: %s, their types are guessed by Elasticsearch", strings.Join(unmapped, ", "))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Warnf("Users mapping has fields which are not in the document: %s", strings.Join(unknown, ", "))
+	}
+
+	required := cfg.MappingRequiredFields
+	if cfg.RetentionDays > 0 {
+		required = append(append([]string{}, required...), cfg.RetentionField)
+	}
+	for _, field := range required {
+		if _, ok := properties[field]; !ok {
+			return fmt.Errorf("required field %s is not in the users mapping", field)
+		}
+	}
+
+	return nil
+}
+
+// documentFields returns JSON names of the fields of the struct type, fields of embedded structs are included.
+func documentFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, documentFields(field.Type)...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+
+	return fields
+}
+
+// checkMapping compares mapping of the existing index with the users mapping and logs fields which differ. When
+// AutoUpdateMapping is set, missing fields are added to the index. Fields with different mapping can't be changed
+// without reindexing so they are only reported.
+func (p *Indexer) checkMapping(ctx context.Context, client *elastic.Client, name string) error {
+	expected, err := p.expectedProperties()
+	if err != nil {
+		return err
+	}
+
+	getCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+	res, err := client.GetMapping().Index(name).Do(getCtx)
+	if err != nil {
+		return fmt.Errorf("can't get mapping of index %s. err: %v", name, err)
+	}
+
+	// mapping of the alias is returned under the name of its index
+	raw, ok := res[name]
+	if !ok && len(res) == 1 {
+		for _, index := range res {
+			raw = index
+		}
+	}
+
+	var current map[string]interface{}
+	if index, ok := raw.(map[string]interface{}); ok {
+		if mappings, ok := index["mappings"].(map[string]interface{}); ok {
+			current, _ = mappings["properties"].(map[string]interface{})
+		}
+	}
+
+	missing := make(map[string]interface{})
+	var changed []string
+	for field, mapping := range expected {
+		actual, ok := current[field]
+		if !ok {
+			missing[field] = mapping
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, mapping) {
+			changed = append(changed, field)
+		}
+	}
+
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		log.Warnf("Mapping of index '%s' differs from users mapping in fields: %s", name, strings.Join(changed, ", "))
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	fields := make([]string, 0, len(missing))
+	for field := range missing {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	if !p.cfg.AutoUpdateMapping {
+		log.Warnf("Mapping of index '%s' misses fields: %s", name, strings.Join(fields, ", "))
+		return nil
+	}
+
+	putCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+	_, err = client.PutMapping().
+		Index(name).
+		BodyJson(map[string]interface{}{"properties": missing}).
+		Do(putCtx)
+	if err != nil {
+		return fmt.Errorf("can't add fields %s to mapping of index %s. err: %v", strings.Join(fields, ", "), name, err)
+	}
+
+	log.Infof("Fields %s added to mapping of index '%s'", strings.Join(fields, ", "), name)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// ackFunc is called once the user of the message leaves the pipeline, whether it was indexed or skipped. It's nil for
+// users which offsets are not tracked.
+type ackFunc func()
+
+// ack calls the function when it's set.
+func (f ackFunc) ack() {
+	if f != nil {
+		f()
+	}
+}
+
+// offsetTracker marks offsets of the session once their users are written. Workers finish users out of order, so the
+// offset of the message is marked only when all previous messages of its partition are finished as well.
+type offsetTracker struct {
+	session sarama.ConsumerGroupSession
+
+	mu sync.Mutex
+	// messages not marked yet by partition, in the order they were consumed
+	pending map[topicPartition][]*trackedMessage
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type trackedMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func newOffsetTracker(session sarama.ConsumerGroupSession) *offsetTracker {
+	return &offsetTracker{session: session, pending: make(map[topicPartition][]*trackedMessage)}
+}
+
+// pendingMessages returns number of consumed messages not marked yet.
+func (t *offsetTracker) pendingMessages() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, pending := range t.pending {
+		n += len(pending)
+	}
+	return n
+}
+
+// track registers consumed message. Returned function finishes it, it's safe to call it more than once.
+func (t *offsetTracker) track(msg *sarama.ConsumerMessage) ackFunc {
+	key := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	tracked := &trackedMessage{msg: msg}
+
+	t.mu.Lock()
+	t.pending[key] = append(t.pending[key], tracked)
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		tracked.done = true
+		pending := t.pending[key]
+		for len(pending) > 0 && pending[0].done {
+			t.session.MarkMessage(pending[0].msg, "")
+			pending = pending[1:]
+		}
+		t.pending[key] = pending
+	}
+}
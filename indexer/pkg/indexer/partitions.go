@@ -0,0 +1,183 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamPartitions consumes users from the Partitions of every topic until ctx is cancelled. Partitions are assigned
+// statically instead of by the consumer group, which is used only to store offsets. Returned channel is closed once
+// consumer is stopped.
+func (p *Indexer) streamPartitions(ctx context.Context, limit *limiter) (chan userEvent, error) {
+	offsets, err := sarama.NewOffsetManagerFromClient(p.group, p.kafkaClient)
+	if err != nil {
+		return nil, fmt.Errorf("can't create offset manager of group %s. err: %v", p.group, err)
+	}
+
+	partitionConsumer, err := sarama.NewConsumerFromClient(p.kafkaClient)
+	if err != nil {
+		return nil, fmt.Errorf("can't create partition consumer. err: %v", err)
+	}
+
+	session := &partitionSession{ctx: ctx, claims: make(map[string][]int32), managers: make(map[string]map[int32]sarama.PartitionOffsetManager)}
+	for _, topic := range p.cfg.Topics {
+		session.managers[topic] = make(map[int32]sarama.PartitionOffsetManager)
+		for _, partition := range p.cfg.Partitions {
+			pom, err := offsets.ManagePartition(topic, partition)
+			if err != nil {
+				return nil, fmt.Errorf("can't manage offset of %s/%d. err: %v", topic, partition, err)
+			}
+			go logErrors(pom.Errors())
+
+			session.claims[topic] = append(session.claims[topic], partition)
+			session.managers[topic][partition] = pom
+		}
+	}
+
+	if err := p.seeker.seek(session); err != nil {
+		return nil, err
+	}
+
+	var claims []sarama.PartitionConsumer
+	for _, topic := range p.cfg.Topics {
+		for _, partition := range p.cfg.Partitions {
+			offset, _ := session.managers[topic][partition].NextOffset()
+			claim, err := partitionConsumer.ConsumePartition(topic, partition, offset)
+			if err != nil {
+				return nil, fmt.Errorf("can't consume %s/%d from offset %d. err: %v", topic, partition, offset, err)
+			}
+			claims = append(claims, claim)
+		}
+		log.Infof("Consuming partitions %v of %s", p.cfg.Partitions, topic)
+	}
+	atomic.StoreInt32(&p.consumerReady, 1)
+
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+	consumer := p.newConsumer(out, limit)
+
+	wg := p.consumeClaims(ctx, consumer, session, claims, nil)
+
+	go func() {
+		<-ctx.Done()
+		log.Println("terminating: context cancelled")
+
+		// marked offsets are committed when offset managers are closed
+		wg.Wait()
+		for _, claim := range claims {
+			claim.AsyncClose()
+		}
+		for _, managers := range session.managers {
+			for _, pom := range managers {
+				pom.AsyncClose()
+			}
+		}
+		if err := offsets.Close(); err != nil {
+			log.Errorf("Error closing offset manager: %v", err)
+		}
+		if err := partitionConsumer.Close(); err != nil {
+			log.Errorf("Error closing partition consumer: %v", err)
+		}
+		if err := p.kafkaClient.Close(); err != nil {
+			log.Errorf("Error closing client: %v", err)
+		}
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// consumeClaims consumes messages of every claim in its own goroutine until ctx is cancelled or consumer stops. When
+// stop is set, it's called with index of the claim after every consumed message and claim is no longer consumed once it
+// returns true. Returned WaitGroup is done when all claims are no longer consumed.
+func (p *Indexer) consumeClaims(ctx context.Context, consumer *Consumer, session sarama.ConsumerGroupSession, claims []sarama.PartitionConsumer, stop func(i int, msg *sarama.ConsumerMessage) bool) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	for i, claim := range claims {
+		wg.Add(1)
+		go func(i int, claim sarama.PartitionConsumer) {
+			defer wg.Done()
+			for {
+				select {
+				case msg, ok := <-claim.Messages():
+					if !ok || !consumer.pauser.wait(ctx.Done()) || !consumer.consume(session, msg) {
+						return
+					}
+					if stop != nil && stop(i, msg) {
+						return
+					}
+				case err := <-claim.Errors():
+					p.consumerErr.WithLabelValues(p.group).Inc()
+					log.Errorf("Error from partition consumer: %v", err)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, claim)
+	}
+
+	return wg
+}
+
+// logErrors logs offset management errors until the channel is closed.
+func logErrors(errors <-chan *sarama.ConsumerError) {
+	for err := range errors {
+		log.Errorf("Error managing offset: %v", err)
+	}
+}
+
+// partitionSession is the sarama.ConsumerGroupSession of statically assigned partitions, offsets are marked with
+// the offset manager of the consumer group.
+type partitionSession struct {
+	ctx      context.Context
+	claims   map[string][]int32
+	managers map[string]map[int32]sarama.PartitionOffsetManager
+}
+
+func (s *partitionSession) Claims() map[string][]int32 { return s.claims }
+func (s *partitionSession) MemberID() string           { return "" }
+func (s *partitionSession) GenerationID() int32        { return -1 }
+func (s *partitionSession) Context() context.Context   { return s.ctx }
+
+func (s *partitionSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if pom, ok := s.managers[topic][partition]; ok {
+		pom.MarkOffset(offset, metadata)
+	}
+}
+
+func (s *partitionSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	if pom, ok := s.managers[topic][partition]; ok {
+		pom.ResetOffset(offset, metadata)
+	}
+}
+
+func (s *partitionSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// checkPartitions returns error when any of the Partitions doesn't exist in any of the consumed topics.
+func checkPartitions(client sarama.Client, topics []string, partitions []int32) error {
+	for _, topic := range topics {
+		available, err := client.Partitions(topic)
+		if err != nil {
+			return fmt.Errorf("can't list partitions of %s. err: %v", topic, err)
+		}
+
+		exists := make(map[int32]bool, len(available))
+		for _, partition := range available {
+			exists[partition] = true
+		}
+
+		for _, partition := range partitions {
+			if !exists[partition] {
+				return fmt.Errorf("partition %d of %s not found; available partitions: %v", partition, topic, available)
+			}
+		}
+	}
+
+	return nil
+}
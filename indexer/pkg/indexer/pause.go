@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pauser stops consumption of messages without leaving the consumer group.
+type pauser struct {
+	mu sync.Mutex
+	// resumed is closed on resume, nil when consumption is not paused
+	resumed chan struct{}
+}
+
+func (p *pauser) pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed != nil {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	return true
+}
+
+func (p *pauser) resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.resumed == nil {
+		return false
+	}
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+func (p *pauser) paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// wait blocks while consumption is paused. It returns false when done is closed first.
+func (p *pauser) wait(done <-chan struct{}) bool {
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Pause stops consuming users from Kafka. Consumer stays in the consumer group and keeps its partitions, messages
+// which are not consumed yet are neither indexed nor committed until Resume is called.
+func (p *Indexer) Pause() {
+	if p.pauser.pause() {
+		log.Warn("Consumption paused")
+	}
+}
+
+// Resume continues consuming users from the first message which was not consumed.
+func (p *Indexer) Resume() {
+	if p.pauser.resume() {
+		log.Info("Consumption resumed")
+	}
+}
+
+// Paused returns true when consumption is paused.
+func (p *Indexer) Paused() bool {
+	return p.pauser.paused()
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/userpb"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// unmarshalProto decodes protobuf encoded userpb.User into userEvent.
+func unmarshalProto(data []byte, v interface{}) error {
+	event, ok := v.(*userEvent)
+	if !ok {
+		return fmt.Errorf("can't decode protobuf message into %T", v)
+	}
+
+	var msg userpb.User
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	*event = userEvent{
+		User: models.User{
+			Pnum:     msg.GetId(),
+			Email:    msg.Email,
+			Dob:      msg.Dob,
+			Weight:   intPtr(msg.Weight),
+			Height:   intPtr(msg.Height),
+			Nickname: msg.Nickname,
+			Country:  int(msg.GetCountry()),
+			City:     msg.City,
+			Caption:  msg.Caption,
+			Gender:   intPtr(msg.Gender),
+			Score:    msg.Score,
+		},
+		Deleted: msg.GetDeleted(),
+		Version: msg.GetVersion(),
+	}
+
+	if msg.Location != nil {
+		event.Location = &models.Location{Latitude: msg.Location.GetLat(), Longitude: msg.Location.GetLon()}
+	}
+
+	return nil
+}
+
+func intPtr(v *int32) *int {
+	if v == nil {
+		return nil
+	}
+
+	i := int(*v)
+	return &i
+}
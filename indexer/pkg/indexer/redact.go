@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// redactMask replaces values of redacted fields in the "mask" mode.
+const redactMask = "***"
+
+// redaction hashes or masks fields of the user, so the original values never reach the sink.
+type redaction func(user *models.User)
+
+// newRedaction returns redaction of the fields. It's applied after users are transformed and filtered, so filters
+// still see the original values. Fields must be text fields of models.User, Dob can't be redacted as age is computed
+// from it.
+func newRedaction(fields []string, mode string) (redaction, error) {
+	if len(fields) == 0 {
+		return func(*models.User) {}, nil
+	}
+
+	for _, name := range fields {
+		field, ok := reflect.TypeOf(models.User{}).FieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("user has no field %q", name)
+		}
+		if field.Type != reflect.TypeOf((*string)(nil)) || name == "Dob" {
+			return nil, fmt.Errorf("user field %q of type %s can't be redacted", name, field.Type)
+		}
+	}
+
+	redact := hashValue
+	if mode == config.RedactModeMask {
+		redact = func(string) string { return redactMask }
+	}
+
+	return func(user *models.User) {
+		v := reflect.ValueOf(user).Elem()
+		for _, name := range fields {
+			field := v.FieldByName(name)
+			if field.IsNil() {
+				continue
+			}
+			redacted := redact(field.Elem().String())
+			field.Set(reflect.ValueOf(&redacted))
+		}
+	}, nil
+}
+
+// hashValue returns hex encoded SHA-256 of the value. Equal values have equal hashes, so redacted fields can still be
+// used for exact matches and aggregations.
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+func TestRedaction(t *testing.T) {
+	message := `{"id":7,"email":" Alice.Smith@Example.com ","nickname":"alice_s","city":"Warsaw","dob":"1990-05-10"}`
+	// original values together with their normalized forms
+	secrets := []string{"Alice.Smith@Example.com", "alice.smith@example.com", "alice_s", "Alice", "Smith"}
+
+	tests := []struct {
+		name     string
+		mode     string
+		redacted string
+	}{
+		{name: "hash", mode: config.RedactModeHash, redacted: hashValue("alice.smith@example.com")},
+		{name: "mask", mode: config.RedactModeMask, redacted: redactMask},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Transformers: []string{"trim", "lowercase_email"},
+				RedactFields: []string{"Email", "Nickname"},
+				RedactMode:   tt.mode,
+			}
+			consumer, out := newTestConsumer(t, cfg)
+			if !consumer.consume(testSession("users"), &sarama.ConsumerMessage{Topic: "users", Value: []byte(message)}) {
+				t.Fatal("consumer stopped")
+			}
+			if len(out) != 1 {
+				t.Fatal("user not passed to the sink")
+			}
+			event := <-out
+
+			p := &Indexer{cfg: cfg}
+			doc, err := p.marshalDocument(event.User, time.Now())
+			if err != nil {
+				t.Fatalf("can't marshal document: %v", err)
+			}
+
+			for _, secret := range secrets {
+				if strings.Contains(string(doc), secret) {
+					t.Errorf("document %s contains redacted value %q", doc, secret)
+				}
+			}
+			if !strings.Contains(string(doc), `"email":"`+tt.redacted+`"`) {
+				t.Errorf("document %s doesn't contain redacted email %q", doc, tt.redacted)
+			}
+			if !strings.Contains(string(doc), `"city":"Warsaw"`) {
+				t.Errorf("document %s doesn't contain not redacted city", doc)
+			}
+		})
+	}
+}
+
+func TestRedactionFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		err    bool
+	}{
+		{name: "text fields", fields: []string{"Email", "Nickname", "City", "Caption"}},
+		{name: "unknown field", fields: []string{"Name"}, err: true},
+		{name: "dob", fields: []string{"Dob"}, err: true},
+		{name: "not a text field", fields: []string{"Weight"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRedaction(tt.fields, config.RedactModeHash); (err != nil) != tt.err {
+				t.Errorf("err = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRedactionAfterFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		email   string
+		skipped bool
+	}{
+		{name: "test user hashed", mode: config.RedactModeHash, email: "bot-1@test.example.com", skipped: true},
+		{name: "test user masked", mode: config.RedactModeMask, email: "bot-2@test.example.com", skipped: true},
+		{name: "real user hashed", mode: config.RedactModeHash, email: "alice@example.com"},
+		{name: "real user masked", mode: config.RedactModeMask, email: "alice@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				RedactFields:     []string{"Email"},
+				RedactMode:       tt.mode,
+				SkipEmailPattern: `@test\.example\.com$`,
+			}
+			consumer, out := newTestConsumer(t, cfg)
+
+			message := &sarama.ConsumerMessage{Topic: "users", Value: []byte(`{"id":1,"email":"` + tt.email + `"}`)}
+			if !consumer.consume(testSession("users"), message) {
+				t.Fatal("consumer stopped")
+			}
+
+			if tt.skipped {
+				if len(out) != 0 {
+					t.Errorf("test user %s passed to the sink", tt.email)
+				}
+				if consumer.counters.filtered != 1 {
+					t.Errorf("%d users filtered, want 1", consumer.counters.filtered)
+				}
+				return
+			}
+
+			if len(out) != 1 {
+				t.Fatalf("user %s not passed to the sink", tt.email)
+			}
+			if event := <-out; event.Email == nil || *event.Email == tt.email {
+				t.Errorf("email %v not redacted", event.Email)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// marshalDocument encodes user as the indexed document with fields renamed by RenameFields.
+func (p *Indexer) marshalDocument(user models.User, now time.Time) ([]byte, error) {
+	doc, err := json.Marshal(newDocument(user, now))
+	if err != nil || len(p.cfg.RenameFields) == 0 {
+		return doc, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(renameFields(fields, p.cfg.RenameFields))
+}
+
+// indexedAtLayout format of the IndexedAtField, it's accepted by the default format of the date type.
+const indexedAtLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// withIndexedAt returns document with IndexedAtField set to now in UTC. Document is unchanged when the field is not
+// set.
+func (p *Indexer) withIndexedAt(doc []byte, now time.Time) ([]byte, error) {
+	if p.cfg.IndexedAtField == "" {
+		return doc, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, err
+	}
+
+	indexedAt, err := json.Marshal(now.UTC().Format(indexedAtLayout))
+	if err != nil {
+		return nil, err
+	}
+	fields[p.cfg.IndexedAtField] = indexedAt
+
+	return json.Marshal(fields)
+}
+
+// usersMapping returns body creating index with users mapping, its fields are renamed by RenameFields. IndexedAtField
+// is mapped as date. Shards, replicas and refresh interval of the config are merged into the settings.
+func (p *Indexer) usersMapping() (string, error) {
+	return usersMapping(p.cfg)
+}
+
+func usersMapping(cfg *config.Config) (string, error) {
+	settings := indexSettings(cfg)
+	if len(cfg.RenameFields) == 0 && cfg.IndexedAtField == "" && len(settings) == 0 {
+		return models.ElasticMappingString, nil
+	}
+
+	var mapping map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(models.ElasticMappingString), &mapping); err != nil {
+		return "", fmt.Errorf("can't parse users mapping. err: %v", err)
+	}
+
+	if raw, ok := mapping["mappings"]; ok {
+		var mappings map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &mappings); err != nil {
+			return "", fmt.Errorf("can't parse mappings of users mapping. err: %v", err)
+		}
+
+		if properties, ok := mappings["properties"]; ok {
+			properties = renameFields(properties, cfg.RenameFields)
+			if cfg.IndexedAtField != "" {
+				properties[cfg.IndexedAtField] = json.RawMessage(`{"type":"date"}`)
+			}
+			mappings["properties"] = properties
+		}
+
+		var err error
+		if mapping["mappings"], err = json.Marshal(mappings); err != nil {
+			return "", err
+		}
+	}
+
+	if len(settings) > 0 {
+		merged := make(map[string]interface{})
+		if raw, ok := mapping["settings"]; ok {
+			if err := json.Unmarshal(raw, &merged); err != nil {
+				return "", fmt.Errorf("can't parse settings of users mapping. err: %v", err)
+			}
+		}
+		for name, value := range settings {
+			merged[name] = value
+		}
+
+		var err error
+		if mapping["settings"], err = json.Marshal(merged); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := json.Marshal(mapping)
+	return string(body), err
+}
+
+// indexSettings returns settings of created indices set in the config, Elasticsearch defaults are used for the others.
+func indexSettings(cfg *config.Config) map[string]interface{} {
+	settings := make(map[string]interface{})
+	if cfg.IndexShards > 0 {
+		settings["number_of_shards"] = cfg.IndexShards
+	}
+	if cfg.IndexReplicas != nil {
+		settings["number_of_replicas"] = *cfg.IndexReplicas
+	}
+	if cfg.IndexRefreshInterval != "" {
+		settings["refresh_interval"] = cfg.IndexRefreshInterval
+	}
+
+	return settings
+}
+
+// renameFields renames keys of fields in place and returns them.
+func renameFields(fields map[string]json.RawMessage, renames map[string]string) map[string]json.RawMessage {
+	for from, to := range renames {
+		if value, ok := fields[from]; ok {
+			delete(fields, from)
+			fields[to] = value
+		}
+	}
+
+	return fields
+}
+
+// Mapping returns pretty-printed body of the index created by the indexer with the config. It fails when the users
+// mapping is not a valid JSON.
+func Mapping(cfg *config.Config) ([]byte, error) {
+	mapping, err := usersMapping(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, []byte(mapping), "", "  "); err != nil {
+		return nil, fmt.Errorf("users mapping is not a valid JSON. err: %v", err)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// resetOffsets commits the oldest available offsets of all partitions of the topics for the group, so the next
+// session replays the topics from the beginning. It must be called before joining the group as offsets of a group with
+// active members can't be committed.
+func resetOffsets(client sarama.Client, group string, topics []string) error {
+	om, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		return fmt.Errorf("can't create offset manager. err: %v", err)
+	}
+
+	var poms []sarama.PartitionOffsetManager
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			om.Close()
+			return fmt.Errorf("can't get partitions of topic %s. err: %v", topic, err)
+		}
+
+		for _, partition := range partitions {
+			oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				om.Close()
+				return fmt.Errorf("can't get oldest offset of %s/%d. err: %v", topic, partition, err)
+			}
+
+			pom, err := om.ManagePartition(topic, partition)
+			if err != nil {
+				om.Close()
+				return fmt.Errorf("can't manage offset of %s/%d. err: %v", topic, partition, err)
+			}
+			poms = append(poms, pom)
+
+			// offset can be moved only forward by mark and only backward by reset
+			pom.MarkOffset(oldest, "replay")
+			pom.ResetOffset(oldest, "replay")
+			log.Infof("Offset of %s/%d for group %s reset to %d", topic, partition, group, oldest)
+		}
+	}
+
+	// offsets are committed when offset manager is closed
+	om.Close()
+	for _, pom := range poms {
+		if err := pom.Close(); err != nil {
+			return fmt.Errorf("can't commit offsets of group %s. err: %v", group, err)
+		}
+	}
+
+	log.Warnf("Offsets of group %s reset to oldest, topics %v are replayed from the beginning", group, topics)
+
+	return nil
+}
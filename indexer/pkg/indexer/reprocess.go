@@ -0,0 +1,126 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// reprocessGroupSuffix is appended to the ConsumerGroup storing offsets of reprocessed dead letters, so the consumer
+// group of the Topics is not affected.
+const reprocessGroupSuffix = "-reprocess-dlq"
+
+// streamDeadLetters consumes users from every partition of the DeadLetterTopic, starting at the offset committed by the
+// previous run. Partitions are consumed up to their newest offset at the start, messages dead-lettered later, including
+// the requeued ones, are left for the next run. Source is stopped once all partitions are consumed. Returned channel is
+// closed once consumer is stopped.
+func (p *Indexer) streamDeadLetters(ctx context.Context, limit *limiter) (chan userEvent, error) {
+	topic := p.cfg.DeadLetterTopic
+	partitions, err := p.kafkaClient.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("can't list partitions of %s. err: %v", topic, err)
+	}
+
+	offsets, err := sarama.NewOffsetManagerFromClient(p.group, p.kafkaClient)
+	if err != nil {
+		return nil, fmt.Errorf("can't create offset manager of group %s. err: %v", p.group, err)
+	}
+
+	partitionConsumer, err := sarama.NewConsumerFromClient(p.kafkaClient)
+	if err != nil {
+		return nil, fmt.Errorf("can't create partition consumer. err: %v", err)
+	}
+
+	session := &partitionSession{
+		ctx:      ctx,
+		claims:   map[string][]int32{topic: partitions},
+		managers: map[string]map[int32]sarama.PartitionOffsetManager{topic: {}},
+	}
+
+	var claims []sarama.PartitionConsumer
+	// offset of the last message of every claim
+	var lastOffsets []int64
+	for _, partition := range partitions {
+		pom, err := offsets.ManagePartition(topic, partition)
+		if err != nil {
+			return nil, fmt.Errorf("can't manage offset of %s/%d. err: %v", topic, partition, err)
+		}
+		go logErrors(pom.Errors())
+		session.managers[topic][partition] = pom
+
+		end, err := p.kafkaClient.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("can't get newest offset of %s/%d. err: %v", topic, partition, err)
+		}
+
+		// all dead letters are reprocessed by the first run regardless of InitialOffset
+		next, _ := pom.NextOffset()
+		if next < 0 {
+			if next, err = p.kafkaClient.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+				return nil, fmt.Errorf("can't get oldest offset of %s/%d. err: %v", topic, partition, err)
+			}
+		}
+		if next >= end {
+			continue
+		}
+
+		claim, err := partitionConsumer.ConsumePartition(topic, partition, next)
+		if err != nil {
+			return nil, fmt.Errorf("can't consume %s/%d from offset %d. err: %v", topic, partition, next, err)
+		}
+		claims = append(claims, claim)
+		lastOffsets = append(lastOffsets, end-1)
+		log.Infof("Reprocessing offsets %d-%d of %s/%d", next, end-1, topic, partition)
+	}
+	atomic.StoreInt32(&p.consumerReady, 1)
+
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+	consumer := p.newConsumer(out, limit)
+
+	if len(claims) == 0 {
+		log.Infof("No messages of %s to reprocess, stopping", topic)
+		limit.stop()
+	}
+
+	remaining := int32(len(claims))
+	wg := p.consumeClaims(ctx, consumer, session, claims, func(i int, msg *sarama.ConsumerMessage) bool {
+		if msg.Offset < lastOffsets[i] {
+			return false
+		}
+
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			log.Infof("All messages of %s reprocessed, stopping", topic)
+			limit.stop()
+		}
+		return true
+	})
+
+	go func() {
+		<-ctx.Done()
+
+		// marked offsets are committed when offset managers are closed
+		wg.Wait()
+		for _, claim := range claims {
+			claim.AsyncClose()
+		}
+		for _, pom := range session.managers[topic] {
+			pom.AsyncClose()
+		}
+		if err := offsets.Close(); err != nil {
+			log.Errorf("Error closing offset manager: %v", err)
+		}
+		if err := partitionConsumer.Close(); err != nil {
+			log.Errorf("Error closing partition consumer: %v", err)
+		}
+		if err := p.kafkaClient.Close(); err != nil {
+			log.Errorf("Error closing client: %v", err)
+		}
+		close(out)
+	}()
+
+	return out, nil
+}
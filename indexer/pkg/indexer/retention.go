@@ -0,0 +1,103 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRetention deletes expired users right away and then every RetentionInterval until ctx is cancelled.
+func (p *Indexer) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.RetentionInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := p.deleteExpired(ctx)
+		if err != nil && ctx.Err() != nil {
+			log.Infof("Retention stopped: %v", err)
+		} else if err != nil {
+			log.Errorf("can't delete expired users. Err: %v", err)
+		} else {
+			log.Infof("Retention: %d users older than %d days deleted", deleted, p.cfg.RetentionDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retentionPollInterval is the delay between checks whether the deletion task is completed.
+var retentionPollInterval = 10 * time.Second
+
+// deleteExpired deletes users which RetentionField is older than RetentionDays from all indices of the IndexPattern and
+// from the index of the WriteAlias. Indices the alias no longer points to are cleaned as long as they match the pattern.
+// Users updated while they are deleted are skipped, they are deleted by the next run if they are still expired.
+// Deletion runs as a task of the cluster, it may take much longer than a single request, so it's started without
+// waiting and polled until it's completed.
+func (p *Indexer) deleteExpired(ctx context.Context) (int64, error) {
+	indices := []string{indexWildcard(p.cfg.IndexPattern)}
+	if p.cfg.WriteAlias != "" {
+		indices = append(indices, p.cfg.WriteAlias)
+	}
+	query := elastic.NewRangeQuery(p.cfg.RetentionField).Lt(fmt.Sprintf("now-%dd", p.cfg.RetentionDays))
+
+	reqCtx, cancel := context.WithTimeout(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	task, err := p.esClient.DeleteByQuery(indices...).
+		Query(query).
+		ProceedOnVersionConflict().
+		AllowNoIndices(true).
+		IgnoreUnavailable(true).
+		DoAsync(reqCtx)
+	if err != nil {
+		return 0, fmt.Errorf("can't delete by query from %v. err: %v", indices, err)
+	}
+	log.Infof("Retention: deleting users older than %d days from %v by task %s", p.cfg.RetentionDays, indices, task.TaskId)
+
+	return p.waitForDeletion(ctx, task.TaskId)
+}
+
+// waitForDeletion polls the delete by query task until it's completed and returns number of deleted users. Task keeps
+// running on the cluster when ctx is cancelled.
+func (p *Indexer) waitForDeletion(ctx context.Context, taskID string) (int64, error) {
+	ticker := time.NewTicker(retentionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("stopped waiting for task %s, it keeps running on the cluster", taskID)
+		case <-ticker.C:
+		}
+
+		reqCtx, cancel := context.WithTimeout(ctx, p.cfg.ElasticTimeout.Duration)
+		res, err := p.esClient.TasksGetTask().TaskId(taskID).Do(reqCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("can't check deletion task %s. Err: %v", taskID, err)
+			}
+			continue
+		}
+		if !res.Completed {
+			continue
+		}
+
+		var deleted int64
+		if res.Task != nil {
+			if status, ok := res.Task.Status.(map[string]interface{}); ok {
+				if n, ok := status["deleted"].(float64); ok {
+					deleted = int64(n)
+				}
+			}
+		}
+		return deleted, nil
+	}
+}
@@ -0,0 +1,113 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+func TestDeleteExpired(t *testing.T) {
+	original := retentionPollInterval
+	retentionPollInterval = time.Millisecond
+	defer func() { retentionPollInterval = original }()
+
+	var polls int32
+	var mu sync.Mutex
+	var path, waitForCompletion string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+			mu.Lock()
+			path, waitForCompletion = r.URL.Path, r.URL.Query().Get("wait_for_completion")
+			mu.Unlock()
+			fmt.Fprint(w, `{"task":"node-1:42"}`)
+		case r.URL.Path == "/_tasks/node-1:42":
+			// task is completed on the third poll
+			if atomic.AddInt32(&polls, 1) < 3 {
+				fmt.Fprint(w, `{"completed":false,"task":{"status":{"deleted":5}}}`)
+				return
+			}
+			fmt.Fprint(w, `{"completed":true,"task":{"status":{"total":12,"deleted":12}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	healthcheck := false
+	cfg := &config.Config{
+		IndexPattern:       "users-%Y",
+		WriteAlias:         "users-write",
+		RetentionField:     "indexed_at",
+		RetentionDays:      30,
+		ElasticTimeout:     config.Duration{Duration: time.Second},
+		ElasticHealthcheck: &healthcheck,
+	}
+	client, err := newElasticClient(cfg, []string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Indexer{cfg: cfg, esClient: client}
+
+	deleted, err := p.deleteExpired(context.Background())
+	if err != nil {
+		t.Fatalf("can't delete expired users: %v", err)
+	}
+
+	if deleted != 12 {
+		t.Errorf("%d users deleted, want 12", deleted)
+	}
+	if n := atomic.LoadInt32(&polls); n != 3 {
+		t.Errorf("task polled %d times, want 3", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/users-*,users-write/_delete_by_query" {
+		t.Errorf("deleted from %s, want all indices of the pattern and the alias", path)
+	}
+	if waitForCompletion != "false" {
+		t.Errorf("wait_for_completion = %q, want false", waitForCompletion)
+	}
+}
+
+func TestDeleteExpiredCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/_delete_by_query") {
+			fmt.Fprint(w, `{"task":"node-1:42"}`)
+			return
+		}
+		fmt.Fprint(w, `{"completed":false}`)
+	}))
+	defer server.Close()
+
+	healthcheck := false
+	cfg := &config.Config{
+		IndexPattern:       "users",
+		RetentionField:     "indexed_at",
+		RetentionDays:      30,
+		ElasticTimeout:     config.Duration{Duration: time.Second},
+		ElasticHealthcheck: &healthcheck,
+	}
+	client, err := newElasticClient(cfg, []string{server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Indexer{cfg: cfg, esClient: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.deleteExpired(ctx); err == nil {
+		t.Error("deletion completed, want error once ctx is cancelled")
+	}
+}
@@ -0,0 +1,48 @@
+package indexer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	jitterMu sync.Mutex
+	// jitterRand randomizes backoff delays, it can be replaced with a seeded source to get deterministic delays.
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns random delay between 0 and d (full jitter), so instances restarted at once don't retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return time.Duration(jitterRand.Int63n(int64(d) + 1))
+}
+
+// retry calls fn until it succeeds or maxRetries is exhausted. Upper limit of the delay between attempts doubles after
+// each failure.
+func retry(name string, maxRetries int, baseBackoff time.Duration, fn func() error) error {
+	backoff := baseBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		if attempt > maxRetries {
+			return err
+		}
+
+		delay := jitter(backoff)
+		log.Warnf("%s failed, retrying in %v (attempt %d/%d). Err: %v", name, delay, attempt, maxRetries, err)
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
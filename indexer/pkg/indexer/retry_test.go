@@ -0,0 +1,101 @@
+package indexer
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// seedJitter replaces jitterRand with a source seeded with seed. Returned function restores the original source.
+func seedJitter(seed int64) func() {
+	jitterMu.Lock()
+	original := jitterRand
+	jitterRand = rand.New(rand.NewSource(seed))
+	jitterMu.Unlock()
+
+	return func() {
+		jitterMu.Lock()
+		jitterRand = original
+		jitterMu.Unlock()
+	}
+}
+
+func TestJitter(t *testing.T) {
+	delays := func() []time.Duration {
+		defer seedJitter(42)()
+
+		var delays []time.Duration
+		for _, d := range []time.Duration{0, -time.Second, time.Millisecond, time.Second, time.Minute} {
+			delays = append(delays, jitter(d))
+		}
+		return delays
+	}
+
+	first, second := delays(), delays()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("delay %d = %v and %v with the same seed, want equal", i, first[i], second[i])
+		}
+	}
+
+	tests := []struct {
+		name string
+		d    time.Duration
+	}{
+		{name: "zero", d: 0},
+		{name: "negative", d: -time.Second},
+		{name: "millisecond", d: time.Millisecond},
+		{name: "second", d: time.Second},
+		{name: "minute", d: time.Minute},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			max := tt.d
+			if max < 0 {
+				max = 0
+			}
+			if first[i] < 0 || first[i] > max {
+				t.Errorf("jitter(%v) = %v, want between 0 and %v", tt.d, first[i], max)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer seedJitter(1)()
+
+	tests := []struct {
+		name       string
+		maxRetries int
+		failures   int
+		calls      int
+		err        bool
+	}{
+		{name: "success", maxRetries: 3, failures: 0, calls: 1},
+		{name: "success after retries", maxRetries: 3, failures: 2, calls: 3},
+		{name: "retries exhausted", maxRetries: 2, failures: 5, calls: 3, err: true},
+		{name: "no retries", maxRetries: 0, failures: 1, calls: 1, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retry(tt.name, tt.maxRetries, time.Millisecond, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			if calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+			if (err != nil) != tt.err {
+				t.Errorf("err = %v, want error: %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package indexer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logSamplerInterval how often number of suppressed messages is logged. Sampling starts over after each interval.
+const logSamplerInterval = time.Minute
+
+// logSampler collapses repeated log messages of the same kind. In every interval first messages are logged, then only
+// every n-th one.
+type logSampler struct {
+	first int64
+	every int64
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newLogSampler(first, every int) *logSampler {
+	return &logSampler{first: int64(first), every: int64(every), counts: make(map[string]int64)}
+}
+
+// allow returns true when message of the given kind should be logged.
+func (s *logSampler) allow(kind string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[kind]++
+	n := s.counts[kind]
+
+	return n <= s.first || (n-s.first)%s.every == 0
+}
+
+// run logs summary of suppressed messages until ctx is cancelled.
+func (s *logSampler) run(ctx context.Context) {
+	ticker := time.NewTicker(logSamplerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.summary()
+			return
+		case <-ticker.C:
+			s.summary()
+		}
+	}
+}
+
+func (s *logSampler) summary() {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[string]int64)
+	s.mu.Unlock()
+
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		total := counts[kind]
+		if suppressed := total - s.first - (total-s.first)/s.every; suppressed > 0 {
+			log.Warnf("%d of %d '%s' messages suppressed since the last summary", suppressed, total, kind)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// timestampSeeker moves claimed partitions to the first message produced at or after the timestamp. Each partition is
+// moved only once so the time window isn't replayed again after a rebalance.
+type timestampSeeker struct {
+	client    sarama.Client
+	timestamp time.Time
+
+	mu     sync.Mutex
+	seeked map[string]bool
+}
+
+func newTimestampSeeker(client sarama.Client, timestamp time.Time) *timestampSeeker {
+	return &timestampSeeker{client: client, timestamp: timestamp, seeked: make(map[string]bool)}
+}
+
+// seek positions all partitions claimed by the session. It must be called before the session starts consuming.
+func (s *timestampSeeker) seek(session sarama.ConsumerGroupSession) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			key := fmt.Sprintf("%s/%d", topic, partition)
+			if s.seeked[key] {
+				continue
+			}
+
+			offset, err := s.client.GetOffset(topic, partition, s.timestamp.UnixNano()/int64(time.Millisecond))
+			if err != nil {
+				return fmt.Errorf("can't get offset of %s at %v. err: %v", key, s.timestamp, err)
+			}
+
+			// there is no message at or after the timestamp
+			if offset < 0 {
+				if offset, err = s.client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+					return fmt.Errorf("can't get newest offset of %s. err: %v", key, err)
+				}
+				log.Infof("No messages in %s after %v, starting at newest offset %d", key, s.timestamp, offset)
+			} else {
+				log.Infof("Starting %s at offset %d (%v)", key, offset, s.timestamp)
+			}
+
+			// offset can be moved only forward by mark and only backward by reset
+			session.MarkOffset(topic, partition, offset, "")
+			session.ResetOffset(topic, partition, offset, "")
+			s.seeked[key] = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// selfTestPnum is the id of the synthetic user indexed by the self-test.
+const selfTestPnum = -1
+
+// SelfTest indexes synthetic user, reads it back and deletes it. It checks connection, mapping and credentials of the
+// Elasticsearch cluster without consuming users from Kafka.
+func (p *Indexer) SelfTest(ctx context.Context) error {
+	if p.esClient == nil {
+		return fmt.Errorf("selftest: elasticsearch sink is disabled")
+	}
+
+	email, nickname, dob := "selftest@indexer.invalid", "selftest", "2000-01-01"
+	user := models.User{Pnum: selfTestPnum, Email: &email, Nickname: &nickname, Dob: &dob, Location: &models.Location{Latitude: 52.23, Longitude: 21.01}}
+	doc, err := p.marshalDocument(user, time.Now())
+	if err != nil {
+		return fmt.Errorf("selftest: can't marshal synthetic user. err: %v", err)
+	}
+
+	id, ok := p.documentID(user)
+	if !ok {
+		return fmt.Errorf("selftest: synthetic user has no %s", p.cfg.IDField)
+	}
+	index := p.indexName(user)
+	routing := p.routing(user)
+
+	if err := p.ensureIndex(ctx, index); err != nil {
+		return fmt.Errorf("selftest: %v", err)
+	}
+
+	reqCtx, cancel := elasticContext(ctx, p.cfg.ElasticTimeout.Duration)
+	defer cancel()
+
+	_, err = p.esClient.Index().Index(index).Id(id).Routing(routing).BodyString(string(doc)).Refresh("true").Do(reqCtx)
+	if err != nil {
+		return fmt.Errorf("selftest: can't index user %s in %s. err: %v", id, index, err)
+	}
+	log.Infof("selftest: user %s indexed in %s", id, index)
+
+	// user is removed even if it doesn't match
+	defer func() {
+		if _, err := p.esClient.Delete().Index(index).Id(id).Routing(routing).Refresh("true").Do(reqCtx); err != nil {
+			log.Errorf("selftest: can't delete user %s from %s. Err: %v", id, index, err)
+			return
+		}
+		log.Infof("selftest: user %s deleted from %s", id, index)
+	}()
+
+	res, err := p.esClient.Get().Index(index).Id(id).Routing(routing).Do(reqCtx)
+	if err != nil {
+		return fmt.Errorf("selftest: can't get user %s from %s. err: %v", id, index, err)
+	}
+
+	var got, expected map[string]interface{}
+	if err := json.Unmarshal(res.Source, &got); err != nil {
+		return fmt.Errorf("selftest: can't decode user %s. err: %v", id, err)
+	}
+	if err := json.Unmarshal(doc, &expected); err != nil {
+		return fmt.Errorf("selftest: can't decode synthetic user. err: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		return fmt.Errorf("selftest: user %s read back as %s, expected %s", id, res.Source, doc)
+	}
+	log.Infof("selftest: user %s read back", id)
+
+	return nil
+}
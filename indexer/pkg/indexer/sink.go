@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// sink is a destination of the consumed users.
+type sink interface {
+	// Index writes user to the sink. Writes may be buffered until Close is called.
+	Index(event userEvent) error
+	// Flush writes buffered users. It may be called concurrently with Index.
+	Flush() error
+	// Close flushes buffered users and releases resources.
+	Close() error
+}
+
+// newSink creates sink selected by the -sink flag, users are copied to the ExtraSinks as well. In dry-run mode users
+// are only logged. ctx aborts pending requests to Elasticsearch on shutdown.
+func (p *Indexer) newSink(ctx context.Context) (sink, error) {
+	if p.cfg.DryRun {
+		return &logSink{p: p}, nil
+	}
+
+	var s sink
+	switch p.cfg.Sink {
+	case config.SinkStdout:
+		s = newJSONSink(os.Stdout, &p.counters)
+	case config.SinkFile:
+		f, err := os.Create(p.cfg.SinkPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't create sink file. err: %v", err)
+		}
+		s = newJSONSink(f, &p.counters)
+	case config.SinkCustom:
+		if p.customSink == nil {
+			return nil, fmt.Errorf("custom sink is not set")
+		}
+		s = &customSink{p: p, sink: p.customSink}
+	default:
+		s = p.newElasticSink(ctx)
+	}
+
+	if len(p.cfg.ExtraSinks) == 0 {
+		return s, nil
+	}
+
+	return p.newFanoutSink(s)
+}
+
+// indexUsers passes all users to the sink and waits until sink is flushed.
+func (p *Indexer) indexUsers(users chan userEvent, s sink) {
+	// slow sink blocks the channel, so consumer is throttled as well
+	throttle := newThrottle(p.cfg.MaxDocsPerSecond)
+	for event := range users {
+		throttle.wait()
+
+		if err := s.Index(event); err != nil {
+			log.Errorf("can't index user %d. Err: %v", event.Pnum, err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		log.Errorf("can't close sink. Err: %v", err)
+	}
+}
+
+// jsonSink writes users as newline-delimited JSON, the same format which is read by the file source.
+type jsonSink struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      *bufio.Writer
+	enc      *json.Encoder
+	counters *counters
+}
+
+func newJSONSink(w io.Writer, counters *counters) *jsonSink {
+	buf := bufio.NewWriter(w)
+	return &jsonSink{w: w, buf: buf, enc: json.NewEncoder(buf), counters: counters}
+}
+
+// Index writes user in a single line.
+func (s *jsonSink) Index(event userEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer atomic.AddInt64(&s.counters.done, 1)
+	defer event.ack.ack()
+	return s.enc.Encode(event)
+}
+
+// Flush writes buffered users to the underlying file.
+func (s *jsonSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Flush()
+}
+
+// Close flushes buffered users and closes underlying file.
+func (s *jsonSink) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	if f, ok := s.w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+
+	return nil
+}
+
+// logSink logs users instead of indexing them. Used in dry-run mode.
+type logSink struct {
+	p *Indexer
+}
+
+// Index logs user together with the document id and index it would be indexed in.
+func (s *logSink) Index(event userEvent) error {
+	id, _ := s.p.documentID(event.User)
+	doc, _ := s.p.marshalDocument(event.User, time.Now())
+	log.WithFields(log.Fields{
+		"id":      id,
+		"index":   s.p.indexName(event.User),
+		"deleted": event.Deleted,
+		"user":    string(doc),
+	}).Debug("dry-run: user not indexed")
+	atomic.AddInt64(&s.p.counters.enqued, 1)
+	atomic.AddInt64(&s.p.counters.done, 1)
+	event.ack.ack()
+
+	return nil
+}
+
+// Flush does nothing.
+func (s *logSink) Flush() error {
+	return nil
+}
+
+// Close does nothing.
+func (s *logSink) Close() error {
+	return nil
+}
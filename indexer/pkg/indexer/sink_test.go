@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// slowSink buffers users and writes them with a delay when it's flushed.
+type slowSink struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	buffered []int64
+	written  []int64
+	closed   bool
+	// indexedAfterClose counts users passed to the closed sink
+	indexedAfterClose int
+}
+
+func (s *slowSink) Index(event userEvent) error {
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.indexedAfterClose++
+	}
+	s.buffered = append(s.buffered, event.Pnum)
+	return nil
+}
+
+func (s *slowSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	time.Sleep(s.delay)
+	s.written = append(s.written, s.buffered...)
+	s.buffered = nil
+	return nil
+}
+
+func (s *slowSink) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestIndexUsersDrainsOnShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		buffered int
+		// sent after shutdown started, e.g. by consumer finishing its last message
+		late int
+	}{
+		{name: "empty channel", buffered: 0},
+		{name: "full channel", buffered: 50},
+		{name: "users sent while draining", buffered: 20, late: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Indexer{cfg: &config.Config{}}
+			s := &slowSink{delay: time.Millisecond}
+
+			users := make(chan userEvent, tt.buffered)
+			for i := 0; i < tt.buffered; i++ {
+				users <- userEvent{User: models.User{Pnum: int64(i)}}
+			}
+			go func() {
+				for i := tt.buffered; i < tt.buffered+tt.late; i++ {
+					users <- userEvent{User: models.User{Pnum: int64(i)}}
+				}
+				// consumer closes channel once ctx is cancelled
+				close(users)
+			}()
+
+			p.indexUsers(users, s)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !s.closed {
+				t.Fatal("sink not closed")
+			}
+			if s.indexedAfterClose > 0 {
+				t.Errorf("%d users passed to the closed sink", s.indexedAfterClose)
+			}
+			if len(s.written) != tt.buffered+tt.late {
+				t.Fatalf("%d users written, want %d", len(s.written), tt.buffered+tt.late)
+			}
+			for i, pnum := range s.written {
+				if pnum != int64(i) {
+					t.Fatalf("user %d written at position %d, want %d", pnum, i, i)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONSinkFlushesOnClose(t *testing.T) {
+	var out bytes.Buffer
+	p := &Indexer{cfg: &config.Config{}}
+
+	users := make(chan userEvent, 10)
+	for i := 1; i <= 10; i++ {
+		users <- userEvent{User: models.User{Pnum: int64(i)}}
+	}
+	close(users)
+
+	p.indexUsers(users, newJSONSink(&out, &p.counters))
+
+	if lines := strings.Count(out.String(), "\n"); lines != 10 {
+		t.Errorf("%d users written, want 10", lines)
+	}
+}
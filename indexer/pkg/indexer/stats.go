@@ -0,0 +1,62 @@
+package indexer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds current counters of the indexer.
+type Stats struct {
+	Received           int64      `json:"received"`
+	Errors             int64      `json:"errors"`
+	Filtered           int64      `json:"filtered"`
+	Enqueued           int64      `json:"enqueued"`
+	Failed             int64      `json:"failed"`
+	Pending            int64      `json:"pending"`
+	InFlightBulks      int64      `json:"inFlightBulks"`
+	Paused             bool       `json:"paused"`
+	Uptime             string     `json:"uptime"`
+	LastSuccessfulBulk *time.Time `json:"lastSuccessfulBulk,omitempty"`
+}
+
+// counters are shared by the consumer and indexing workers, all fields are accessed atomically.
+type counters struct {
+	received int64
+	errors   int64
+	filtered int64
+	enqued   int64
+	failed   int64
+	// bulk requests currently executed
+	inFlight int64
+	// users which left the pipeline: indexed, rejected or skipped
+	done int64
+	// unix nano timestamp of the last successful bulk request
+	lastBulk int64
+}
+
+// Stats returns current counters of the indexer.
+func (p *Indexer) Stats() Stats {
+	stats := Stats{
+		Received:      atomic.LoadInt64(&p.counters.received),
+		Errors:        atomic.LoadInt64(&p.counters.errors),
+		Filtered:      atomic.LoadInt64(&p.counters.filtered),
+		Enqueued:      atomic.LoadInt64(&p.counters.enqued),
+		Failed:        atomic.LoadInt64(&p.counters.failed),
+		Pending:       p.Pending(),
+		InFlightBulks: atomic.LoadInt64(&p.counters.inFlight),
+		Paused:        p.Paused(),
+		Uptime:        time.Since(p.started).Round(time.Second).String(),
+	}
+
+	if lastBulk := atomic.LoadInt64(&p.counters.lastBulk); lastBulk > 0 {
+		t := time.Unix(0, lastBulk)
+		stats.LastSuccessfulBulk = &t
+	}
+
+	return stats
+}
+
+// Pending returns number of consumed users which are not indexed yet.
+func (p *Indexer) Pending() int64 {
+	return atomic.LoadInt64(&p.counters.received) - atomic.LoadInt64(&p.counters.done)
+}
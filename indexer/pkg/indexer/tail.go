@@ -0,0 +1,68 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamTail consumes users from the newest offset of every partition of the Topics, or of the Partitions when set,
+// until ctx is cancelled. No consumer group is joined and offsets are never committed, so committed offsets of consumer
+// groups are not affected. Returned channel is closed once consumer is stopped.
+func (p *Indexer) streamTail(ctx context.Context, limit *limiter) (chan userEvent, error) {
+	partitionConsumer, err := sarama.NewConsumerFromClient(p.kafkaClient)
+	if err != nil {
+		return nil, fmt.Errorf("can't create partition consumer. err: %v", err)
+	}
+
+	// session without offset managers drops marked offsets
+	session := &partitionSession{ctx: ctx, claims: make(map[string][]int32)}
+	var claims []sarama.PartitionConsumer
+	for _, topic := range p.cfg.Topics {
+		partitions := p.cfg.Partitions
+		if len(partitions) == 0 {
+			if partitions, err = p.kafkaClient.Partitions(topic); err != nil {
+				return nil, fmt.Errorf("can't list partitions of %s. err: %v", topic, err)
+			}
+		}
+
+		for _, partition := range partitions {
+			claim, err := partitionConsumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("can't consume %s/%d from the newest offset. err: %v", topic, partition, err)
+			}
+			claims = append(claims, claim)
+		}
+		session.claims[topic] = partitions
+		log.Infof("Tailing partitions %v of %s", partitions, topic)
+	}
+	atomic.StoreInt32(&p.consumerReady, 1)
+
+	out := make(chan userEvent, p.cfg.ChannelBuffer)
+	go watchBackpressure(ctx, out)
+	consumer := p.newConsumer(out, limit)
+
+	wg := p.consumeClaims(ctx, consumer, session, claims, nil)
+
+	go func() {
+		<-ctx.Done()
+		log.Println("terminating: context cancelled")
+
+		wg.Wait()
+		for _, claim := range claims {
+			claim.AsyncClose()
+		}
+		if err := partitionConsumer.Close(); err != nil {
+			log.Errorf("Error closing partition consumer: %v", err)
+		}
+		if err := p.kafkaClient.Close(); err != nil {
+			log.Errorf("Error closing client: %v", err)
+		}
+		close(out)
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,41 @@
+package indexer
+
+import (
+	"time"
+)
+
+// throttle is a token bucket limiting number of users passed to the sink per second. Bucket holds up to one second
+// of tokens, so short bursts after idle periods are not delayed. It's used by a single goroutine only.
+type throttle struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newThrottle returns throttle of perSecond users, nil when perSecond is not positive.
+func newThrottle(perSecond int) *throttle {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &throttle{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+// wait blocks until the next user can be passed to the sink.
+func (t *throttle) wait() {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.rate {
+		t.tokens = t.rate
+	}
+	t.last = now
+
+	t.tokens--
+	if t.tokens < 0 {
+		time.Sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,41 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond int
+		users     int
+		// minimum time of passing the users, the first second of users is a burst passed right away
+		min time.Duration
+		max time.Duration
+	}{
+		{name: "disabled", perSecond: 0, users: 10000, min: 0, max: 100 * time.Millisecond},
+		{name: "burst", perSecond: 200, users: 200, min: 0, max: 100 * time.Millisecond},
+		{name: "throttled", perSecond: 200, users: 300, min: 450 * time.Millisecond, max: 1500 * time.Millisecond},
+		{name: "throttled longer", perSecond: 100, users: 200, min: 950 * time.Millisecond, max: 2500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := newThrottle(tt.perSecond)
+			if tt.perSecond <= 0 && throttle != nil {
+				t.Fatal("throttle enabled, want disabled")
+			}
+
+			start := time.Now()
+			for i := 0; i < tt.users; i++ {
+				throttle.wait()
+			}
+			elapsed := time.Since(start)
+
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("%d users passed in %v, want between %v and %v", tt.users, elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
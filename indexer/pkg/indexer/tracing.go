@@ -0,0 +1,84 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the indexer spans.
+const tracerName = "github.com/mateuszdyminski/am-pipeline/indexer"
+
+// tracer creates spans of the indexer. It's a no-op tracer until tracing is enabled.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// newTracerProvider creates provider exporting spans to the OTLP endpoint over HTTP. It returns nil when tracing is
+// disabled.
+func newTracerProvider(cfg *config.Config) (*sdktrace.TracerProvider, error) {
+	if cfg.TracingEndpoint == "" {
+		return nil, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.TracingEndpoint)}
+	if cfg.TracingInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("can't create trace exporter. err: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(version.AppName),
+			semconv.ServiceVersionKey.String(version.AppVersion),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider, nil
+}
+
+// headersCarrier reads trace context from headers of the Kafka message.
+type headersCarrier []*sarama.RecordHeader
+
+// Get implements propagation.TextMapCarrier.
+func (c headersCarrier) Get(key string) string {
+	for _, h := range c {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set implements propagation.TextMapCarrier. Headers of consumed messages are never modified.
+func (c headersCarrier) Set(key, value string) {}
+
+// Keys implements propagation.TextMapCarrier.
+func (c headersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
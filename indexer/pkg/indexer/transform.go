@@ -0,0 +1,133 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// unmarshalFunc decodes message in the MessageFormat.
+type unmarshalFunc func(data []byte, v interface{}) error
+
+// strictUnmarshal decodes JSON like json.Unmarshal but fails on fields unknown to the user event, which reveals
+// changes of the producers' schema.
+func strictUnmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("strict decoding failed: %v", err)
+	}
+
+	return nil
+}
+
+// jsonUnmarshal returns decoder of JSON messages, strict when StrictDecode is set.
+func jsonUnmarshal(cfg *config.Config) unmarshalFunc {
+	if cfg.StrictDecode {
+		return strictUnmarshal
+	}
+
+	return json.Unmarshal
+}
+
+// errProcessingTimeout is returned when decoding and transforming of the message takes longer than MessageTimeout.
+var errProcessingTimeout = errors.New("processing timeout exceeded")
+
+// decodeEventTimeout calls decodeEvent and stops waiting for it after timeout, so a stuck transformation doesn't stop
+// the consumption. Stuck goroutine can't be interrupted, it exits once decodeEvent returns. Timeout is not applied
+// when it's zero.
+func decodeEventTimeout(cfg *config.Config, unmarshal unmarshalFunc, transform transformer, data []byte, timeout time.Duration) (userEvent, bool, error) {
+	if timeout <= 0 {
+		return decodeEvent(cfg, unmarshal, transform, data)
+	}
+
+	type result struct {
+		event userEvent
+		ok    bool
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		event, ok, err := decodeEvent(cfg, unmarshal, transform, data)
+		done <- result{event: event, ok: ok, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.event, r.ok, r.err
+	case <-timer.C:
+		return userEvent{}, false, errProcessingTimeout
+	}
+}
+
+// decodeEvent decodes user event and prepares it for indexing. It returns false when event should be skipped.
+func decodeEvent(cfg *config.Config, unmarshal unmarshalFunc, transform transformer, data []byte) (userEvent, bool, error) {
+	var event userEvent
+	if err := unmarshal(data, &event); err != nil {
+		return event, false, err
+	}
+
+	ok, err := prepareEvent(cfg, transform, &event)
+	return event, ok, err
+}
+
+// prepareEvent applies DobPolicy and transformations to the decoded user. It returns false when event should be
+// skipped.
+func prepareEvent(cfg *config.Config, transform transformer, event *userEvent) (bool, error) {
+	// tombstones are only used to find the document to delete
+	if event.Deleted {
+		return true, nil
+	}
+
+	if !applyDobPolicy(&event.User, cfg.DobPolicy, cfg.DobDefault) {
+		log.Debugf("user %d without date of birth dropped", event.Pnum)
+		return false, nil
+	}
+
+	if err := transform(&event.User); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// zeroDob is sent by the feeders for users without date of birth.
+const zeroDob = "0000-00-00"
+
+// Policies of handling users without date of birth.
+const (
+	// DobPolicyNullify removes date of birth from the document.
+	DobPolicyNullify = "nullify"
+	// DobPolicyDrop skips indexing of the user.
+	DobPolicyDrop = "drop"
+	// DobPolicyDefault sets date of birth to the configured DobDefault.
+	DobPolicyDefault = "default"
+)
+
+// applyDobPolicy handles user without date of birth according to the policy. Messages without the dob key are handled
+// in the same way as the zero date sent by the feeders. It returns false when user should be dropped.
+func applyDobPolicy(user *models.User, policy, defaultDob string) bool {
+	if user.Dob != nil && *user.Dob != zeroDob {
+		return true
+	}
+
+	switch policy {
+	case DobPolicyDrop:
+		return false
+	case DobPolicyDefault:
+		dob := defaultDob
+		user.Dob = &dob
+	default:
+		user.Dob = nil
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+)
+
+func TestDecodeEventDob(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		policy  string
+		strict  bool
+		ok      bool
+		dob     string
+	}{
+		{name: "no dob key", message: `{"id":1,"email":"a@example.com"}`, policy: DobPolicyNullify, ok: true},
+		{name: "null dob", message: `{"id":1,"dob":null}`, policy: DobPolicyNullify, ok: true},
+		{name: "zero dob", message: `{"id":1,"dob":"0000-00-00"}`, policy: DobPolicyNullify, ok: true},
+		{name: "valid dob", message: `{"id":1,"dob":"1990-05-10"}`, policy: DobPolicyNullify, ok: true, dob: "1990-05-10"},
+		{name: "no dob key dropped", message: `{"id":1}`, policy: DobPolicyDrop, ok: false},
+		{name: "no dob key default", message: `{"id":1}`, policy: DobPolicyDefault, ok: true, dob: "1970-01-01"},
+		{name: "no dob key with strict decoding", message: `{"id":1,"email":"a@example.com"}`, policy: DobPolicyNullify, strict: true, ok: true},
+		{name: "deleted without dob", message: `{"id":1,"deleted":true}`, policy: DobPolicyDrop, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				DobPolicy:      tt.policy,
+				DobDefault:     "1970-01-01",
+				StrictDecode:   tt.strict,
+				IndexPattern:   "users-%Y",
+				IndexDateField: "dob",
+			}
+			transform, err := newTransformChain(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event, ok, err := decodeEvent(cfg, jsonUnmarshal(cfg), transform, []byte(tt.message))
+			if err != nil {
+				t.Fatalf("can't decode event: %v", err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+
+			switch {
+			case tt.dob == "" && event.Dob != nil:
+				t.Errorf("Dob = %q, want nil", *event.Dob)
+			case tt.dob != "" && (event.Dob == nil || *event.Dob != tt.dob):
+				t.Errorf("Dob = %v, want %q", event.Dob, tt.dob)
+			}
+
+			// document and index name are derived from Dob as well
+			p := &Indexer{cfg: cfg}
+			if _, err := p.marshalDocument(event.User, time.Now()); err != nil {
+				t.Errorf("can't marshal document: %v", err)
+			}
+			if index := p.indexName(event.User); index == "" {
+				t.Error("empty index name")
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// transformer normalizes user before indexing. User with transformation error is not indexed.
+type transformer func(user *models.User) error
+
+// transformers available in the Transformers config field.
+var transformers = map[string]transformer{
+	"lowercase_email": lowercaseEmail,
+	"trim":            trimSpace,
+}
+
+// newTransformChain returns transformer calling named transformers in order. Chain stops at the first error.
+func newTransformChain(names []string) (transformer, error) {
+	chain := make([]transformer, 0, len(names))
+	for _, name := range names {
+		t, ok := transformers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transformer %q", name)
+		}
+		chain = append(chain, t)
+	}
+
+	return func(user *models.User) error {
+		for i, t := range chain {
+			if err := t(user); err != nil {
+				return fmt.Errorf("transformer %s failed. err: %v", names[i], err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// lowercaseEmail lowercases user's email.
+func lowercaseEmail(user *models.User) error {
+	if user.Email != nil {
+		email := strings.ToLower(*user.Email)
+		user.Email = &email
+	}
+	return nil
+}
+
+// trimSpace trims leading and trailing whitespaces of user's text fields.
+func trimSpace(user *models.User) error {
+	for _, field := range []**string{&user.Email, &user.Nickname, &user.City, &user.Caption} {
+		if *field != nil {
+			trimmed := strings.TrimSpace(**field)
+			*field = &trimmed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package indexer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// unchangedCache remembers hashes of the last indexed documents, so snapshots of unchanged users are not indexed again.
+// Least recently used documents are evicted once the cache holds size documents. It's nil when disabled.
+type unchangedCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// most recently used documents are at the front
+	order *list.List
+}
+
+type unchangedEntry struct {
+	key  string
+	hash docHash
+}
+
+// docHash is SHA-256 of the document.
+type docHash [sha256.Size]byte
+
+// hashDocument returns hash compared by the unchanged documents cache.
+func hashDocument(doc []byte) docHash {
+	return sha256.Sum256(doc)
+}
+
+func newUnchangedCache(size int) *unchangedCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &unchangedCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// unchanged returns true when document with the key was already indexed with the same hash.
+func (c *unchangedCache) unchanged(key string, hash docHash) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok || elem.Value.(*unchangedEntry).hash != hash {
+		return false
+	}
+
+	c.order.MoveToFront(elem)
+	return true
+}
+
+// indexed stores hash of the indexed document.
+func (c *unchangedCache) indexed(key string, hash docHash) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*unchangedEntry).hash = hash
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&unchangedEntry{key: key, hash: hash})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*unchangedEntry).key)
+	}
+}
+
+// forget removes hash of the document, e.g. when user is deleted.
+func (c *unchangedCache) forget(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+	}
+}
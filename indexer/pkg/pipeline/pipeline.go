@@ -0,0 +1,134 @@
+// Package pipeline runs the indexer as a library, so it can be embedded in other programs, e.g. together with the
+// feeder in a single binary:
+//
+//	cfg, err := config.LoadConfig("config/conf.toml")
+//	if err != nil {
+//		return err
+//	}
+//
+//	p, err := pipeline.New(cfg, pipeline.WithSource(users), pipeline.WithoutHTTP())
+//	if err != nil {
+//		return err
+//	}
+//
+//	// Run returns when ctx is cancelled, or the source is stopped, and all consumed users are flushed
+//	return p.Run(ctx)
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/server"
+)
+
+// Pipeline consumes users from the source and writes them to the sink together with serving the HTTP endpoints of
+// health checks, metrics and control.
+type Pipeline struct {
+	cfg     *config.Config
+	indexer *indexer.Indexer
+
+	source    indexer.Source
+	sink      indexer.Sink
+	serveHTTP bool
+}
+
+// WithSource reads users from the source instead of the Source of the config.
+func WithSource(source indexer.Source) func(*Pipeline) {
+	return func(p *Pipeline) {
+		p.source = source
+	}
+}
+
+// WithSink writes users to the sink instead of the Sink of the config.
+func WithSink(sink indexer.Sink) func(*Pipeline) {
+	return func(p *Pipeline) {
+		p.sink = sink
+	}
+}
+
+// WithoutHTTP doesn't serve the HTTP endpoints, e.g. when the embedding program serves its own on the HTTPPort.
+func WithoutHTTP() func(*Pipeline) {
+	return func(p *Pipeline) {
+		p.serveHTTP = false
+	}
+}
+
+// New creates pipeline of the config. Config is completed with defaults and validated, it must not be changed
+// afterwards. Connections to Kafka and Elasticsearch are opened when they are used.
+func New(cfg *config.Config, options ...func(*Pipeline)) (*Pipeline, error) {
+	p := &Pipeline{cfg: cfg, serveHTTP: true}
+	for _, f := range options {
+		f(p)
+	}
+
+	if p.source != nil {
+		cfg.Source = config.SourceCustom
+	}
+	if p.sink != nil {
+		cfg.Sink = config.SinkCustom
+	}
+
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	i, err := indexer.NewIndexer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("can't create indexer. err: %v", err)
+	}
+	i.SetSource(p.source)
+	i.SetSink(p.sink)
+	p.indexer = i
+
+	return p, nil
+}
+
+// Indexer returns the indexer of the pipeline, e.g. to pause it or to read its stats.
+func (p *Pipeline) Indexer() *indexer.Indexer {
+	return p.indexer
+}
+
+// Run indexes users until ctx is cancelled or the source is stopped, e.g. once whole file is read. It returns once all
+// consumed users are flushed, or with error when they are not flushed within ShutdownTimeout after the stop. In
+// self-test mode it only runs the self-test.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if p.cfg.SelfTest {
+		return p.indexer.SelfTest(ctx)
+	}
+
+	// cancel is called when indexer finishes on its own, so the HTTP server is stopped as well
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		done <- p.indexer.Index(ctx)
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if p.serveHTTP {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.ListenAndServe(p.indexer, p.cfg, ctx)
+		}()
+	}
+
+	<-ctx.Done()
+
+	// don't hang forever when remaining users can't be flushed, e.g. when Elasticsearch is down
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(p.cfg.ShutdownTimeout.Duration):
+		return fmt.Errorf("indexer didn't stop within %v, %d users left unindexed", p.cfg.ShutdownTimeout.Duration, p.indexer.Pending())
+	}
+}
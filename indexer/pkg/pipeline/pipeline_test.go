@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
+	"github.com/mateuszdyminski/am-pipeline/models"
+)
+
+// recordingSink records users passed to it.
+type recordingSink struct {
+	mu      sync.Mutex
+	indexed []int64
+	deleted []int64
+	closed  bool
+}
+
+func (s *recordingSink) Index(event indexer.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if event.Deleted {
+		s.deleted = append(s.deleted, event.Pnum)
+	} else {
+		s.indexed = append(s.indexed, event.Pnum)
+	}
+	return nil
+}
+
+func (s *recordingSink) Flush() error {
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// sliceSource passes events to the pipeline and stops once all are sent. When hold is set it keeps running until ctx
+// is cancelled.
+func sliceSource(events []indexer.Event, hold bool) indexer.Source {
+	return func(ctx context.Context) (<-chan indexer.Event, error) {
+		out := make(chan indexer.Event)
+		go func() {
+			defer close(out)
+			for _, e := range events {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if hold {
+				<-ctx.Done()
+			}
+		}()
+		return out, nil
+	}
+}
+
+func email(s string) *string {
+	return &s
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Brokers:          []string{"localhost:9092"},
+		Topics:           []string{"users"},
+		Elastics:         []string{"http://localhost:9200"},
+		HTTPPort:         8080,
+		IndexPattern:     "users",
+		SkipEmailPattern: "@test\\.com$",
+	}
+}
+
+func TestRun(t *testing.T) {
+	events := []indexer.Event{
+		{User: models.User{Pnum: 1, Email: email("john@example.com")}},
+		{User: models.User{Pnum: 2, Email: email("test@test.com")}},
+		{User: models.User{Pnum: 3}, Deleted: true},
+		{User: models.User{Pnum: 4, Email: email("jane@example.com")}},
+	}
+	sink := &recordingSink{}
+
+	p, err := New(testConfig(), WithSource(sliceSource(events, false)), WithSink(sink), WithoutHTTP())
+	if err != nil {
+		t.Fatalf("can't create pipeline: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- p.Run(context.Background()) }()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once the source was stopped")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.indexed) != 2 || sink.indexed[0] != 1 || sink.indexed[1] != 4 {
+		t.Errorf("indexed users = %v, want [1 4]", sink.indexed)
+	}
+	if len(sink.deleted) != 1 || sink.deleted[0] != 3 {
+		t.Errorf("deleted users = %v, want [3]", sink.deleted)
+	}
+	if !sink.closed {
+		t.Error("sink wasn't closed")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	events := []indexer.Event{
+		{User: models.User{Pnum: 1, Email: email("john@example.com")}},
+	}
+	sink := &recordingSink{}
+
+	p, err := New(testConfig(), WithSource(sliceSource(events, true)), WithSink(sink), WithoutHTTP())
+	if err != nil {
+		t.Fatalf("can't create pipeline: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- p.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once ctx was cancelled")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.indexed) != 1 || !sink.closed {
+		t.Errorf("indexed users = %v, closed = %v, want [1] and closed sink", sink.indexed, sink.closed)
+	}
+}
+
+func TestNewWithoutCustomSource(t *testing.T) {
+	cfg := testConfig()
+	cfg.Source = config.SourceCustom
+	cfg.Sink = config.SinkCustom
+
+	p, err := New(cfg, WithSink(&recordingSink{}), WithoutHTTP())
+	if err != nil {
+		t.Fatalf("can't create pipeline: %v", err)
+	}
+	if err := p.Run(context.Background()); err == nil {
+		t.Error("Run of custom source which isn't set succeeded, want error")
+	}
+}
@@ -30,6 +30,19 @@ func (s *Server) version(w http.ResponseWriter, r *http.Request) {
 	w.Write(d)
 }
 
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	d, err := json.Marshal(s.i.Stats())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d)
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	if atomic.LoadInt32(&healthy) == 1 {
 		w.WriteHeader(http.StatusOK)
@@ -40,10 +53,40 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
-	if atomic.LoadInt32(&ready) == 1 {
+	if atomic.LoadInt32(&ready) == 1 && s.i.Ready() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 		return
 	}
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.i.Pause()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("paused"))
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.i.Resume()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("resumed"))
+}
+
+func (s *Server) alias(w http.ResponseWriter, r *http.Request) {
+	index := r.URL.Query().Get("index")
+	if index == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("index query parameter is required"))
+		return
+	}
+
+	if err := s.i.SwitchAlias(r.Context(), index); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("alias points to " + index))
+}
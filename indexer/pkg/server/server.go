@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/config"
+	"github.com/mateuszdyminski/am-pipeline/indexer/pkg/indexer"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -22,10 +23,14 @@ var (
 
 type Server struct {
 	mux *mux.Router
+	i   *indexer.Indexer
 }
 
-func NewServer(cfg *config.Config, options ...func(*Server)) *Server {
-	s := &Server{mux: mux.NewRouter()}
+func NewServer(cfg *config.Config, indexer *indexer.Indexer, options ...func(*Server)) *Server {
+	s := &Server{
+		mux: mux.NewRouter(),
+		i:   indexer,
+	}
 
 	for _, f := range options {
 		f(s)
@@ -34,7 +39,15 @@ func NewServer(cfg *config.Config, options ...func(*Server)) *Server {
 	// general handlers
 	s.mux.HandleFunc("/health", s.health)
 	s.mux.HandleFunc("/ready", s.ready)
+	s.mux.HandleFunc("/healthz", s.health)
+	s.mux.HandleFunc("/readyz", s.ready)
 	s.mux.HandleFunc("/version", s.version)
+	s.mux.HandleFunc("/stats", s.stats)
+
+	// control handlers
+	s.mux.HandleFunc("/pause", s.pause).Methods(http.MethodPost)
+	s.mux.HandleFunc("/resume", s.resume).Methods(http.MethodPost)
+	s.mux.HandleFunc("/alias", s.alias).Methods(http.MethodPost)
 
 	// metrics
 	s.mux.Handle("/metrics", promhttp.Handler())
@@ -48,11 +61,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
-func ListenAndServe(cfg *config.Config, cancelCtx context.Context) {
+func ListenAndServe(indexer *indexer.Indexer, cfg *config.Config, cancelCtx context.Context) {
 	inst := NewInstrument()
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.HTTPPort),
-		Handler:      inst.Wrap(NewServer(cfg)),
+		Handler:      inst.Wrap(NewServer(cfg, indexer)),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 1 * time.Minute,
 		IdleTimeout:  15 * time.Second,
@@ -5,15 +5,19 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// SetupSignalContext returns context cancelled on SIGINT or SIGTERM. Second signal exits the process immediately.
 func SetupSignalContext() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-c
+		sig := <-c
+		log.Infof("Received %s signal, shutting down gracefully", sig)
 		cancel()
 		<-c
 		os.Exit(1) // second signal. Exit directly.
@@ -0,0 +1,97 @@
+// Package userpb holds protobuf messages of user events described in user.proto. Messages are decoded with
+// github.com/golang/protobuf using the struct tags, so they have to be kept in sync with user.proto.
+package userpb
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// User is the protobuf encoded user event.
+type User struct {
+	Id       *int64    `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Email    *string   `protobuf:"bytes,2,opt,name=email" json:"email,omitempty"`
+	Dob      *string   `protobuf:"bytes,3,opt,name=dob" json:"dob,omitempty"`
+	Weight   *int32    `protobuf:"varint,4,opt,name=weight" json:"weight,omitempty"`
+	Height   *int32    `protobuf:"varint,5,opt,name=height" json:"height,omitempty"`
+	Nickname *string   `protobuf:"bytes,6,opt,name=nickname" json:"nickname,omitempty"`
+	Country  *int32    `protobuf:"varint,7,opt,name=country" json:"country,omitempty"`
+	City     *string   `protobuf:"bytes,8,opt,name=city" json:"city,omitempty"`
+	Caption  *string   `protobuf:"bytes,9,opt,name=caption" json:"caption,omitempty"`
+	Location *Location `protobuf:"bytes,10,opt,name=location" json:"location,omitempty"`
+	Gender   *int32    `protobuf:"varint,11,opt,name=gender" json:"gender,omitempty"`
+	Score    *float64  `protobuf:"fixed64,12,opt,name=score" json:"score,omitempty"`
+	Deleted  *bool     `protobuf:"varint,13,opt,name=deleted" json:"deleted,omitempty"`
+	Version  *int64    `protobuf:"varint,14,opt,name=version" json:"version,omitempty"`
+}
+
+// Reset implements proto.Message.
+func (m *User) Reset() { *m = User{} }
+
+// String implements proto.Message.
+func (m *User) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message.
+func (*User) ProtoMessage() {}
+
+// GetId returns Id or zero when it's not set.
+func (m *User) GetId() int64 {
+	if m != nil && m.Id != nil {
+		return *m.Id
+	}
+	return 0
+}
+
+// GetCountry returns Country or zero when it's not set.
+func (m *User) GetCountry() int32 {
+	if m != nil && m.Country != nil {
+		return *m.Country
+	}
+	return 0
+}
+
+// GetDeleted returns Deleted or false when it's not set.
+func (m *User) GetDeleted() bool {
+	if m != nil && m.Deleted != nil {
+		return *m.Deleted
+	}
+	return false
+}
+
+// GetVersion returns Version or zero when it's not set.
+func (m *User) GetVersion() int64 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+// Location of the user.
+type Location struct {
+	Lat *float64 `protobuf:"fixed64,1,opt,name=lat" json:"lat,omitempty"`
+	Lon *float64 `protobuf:"fixed64,2,opt,name=lon" json:"lon,omitempty"`
+}
+
+// Reset implements proto.Message.
+func (m *Location) Reset() { *m = Location{} }
+
+// String implements proto.Message.
+func (m *Location) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message.
+func (*Location) ProtoMessage() {}
+
+// GetLat returns Lat or zero when it's not set.
+func (m *Location) GetLat() float64 {
+	if m != nil && m.Lat != nil {
+		return *m.Lat
+	}
+	return 0
+}
+
+// GetLon returns Lon or zero when it's not set.
+func (m *Location) GetLon() float64 {
+	if m != nil && m.Lon != nil {
+		return *m.Lon
+	}
+	return 0
+}